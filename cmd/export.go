@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/nanassito/air/pkg/models"
+	"github.com/nanassito/air/pkg/tsdb"
+)
+
+// parseTime accepts either a timestamp or a duration back from now, like "24h".
+func parseTime(value string) (time.Time, error) {
+	if d, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04", "2006-01-02 15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time: %q", value)
+}
+
+// export writes the history of the units, one row per change, for analysis in a spreadsheet or notebook.
+func export(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	dir := flags.String("history", "", "Directory the history is persisted in.")
+	from := flags.String("from", "24h", "Start of the export, a timestamp or a duration back from now.")
+	to := flags.String("to", "0s", "End of the export, a timestamp or a duration back from now.")
+	format := flags.String("format", "csv", "Output format, csv or json.")
+	unit := flags.String("unit", "", "Only export this unit.")
+	if err := flags.Parse(args); errors.Is(err, flag.ErrHelp) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if *dir == "" {
+		return fmt.Errorf("-history is required")
+	}
+	start, err := parseTime(*from)
+	if err != nil {
+		return err
+	}
+	end, err := parseTime(*to)
+	if err != nil {
+		return err
+	}
+	store, err := tsdb.OpenReadOnly(*dir)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	units := make([]string, 0, len(sensors))
+	for name := range sensors {
+		if *unit == "" || *unit == name {
+			units = append(units, name)
+		}
+	}
+	if len(units) == 0 {
+		return fmt.Errorf("unknown unit: %q", *unit)
+	}
+	sort.Strings(units)
+
+	switch *format {
+	case "csv":
+		return exportCsv(store, units, start, end, out)
+	case "json":
+		return exportJson(store, units, start, end, out)
+	default:
+		return fmt.Errorf("unknown format: %q", *format)
+	}
+}
+
+func exportCsv(store *tsdb.Store, units []string, from time.Time, to time.Time, out io.Writer) error {
+	writer := csv.NewWriter(out)
+	header := []string{"time", "unit"}
+	for _, column := range models.HistoryColumns("", "") {
+		header = append(header, column.Name)
+	}
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+	for _, name := range units {
+		columns := models.HistoryColumns(name, sensors[name])
+		rows, err := models.HistoryRows(store, columns, from, to)
+		if err != nil {
+			return err
+		}
+		for _, row := range rows {
+			record := []string{row.Time.Format(time.RFC3339), name}
+			for _, column := range columns {
+				record = append(record, row.Values[column.Name])
+			}
+			if err := writer.Write(record); err != nil {
+				return err
+			}
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func exportJson(store *tsdb.Store, units []string, from time.Time, to time.Time, out io.Writer) error {
+	records := make([]map[string]any, 0)
+	for _, name := range units {
+		columns := models.HistoryColumns(name, sensors[name])
+		rows, err := models.HistoryRows(store, columns, from, to)
+		if err != nil {
+			return err
+		}
+		for _, row := range rows {
+			record := map[string]any{"time": row.Time, "unit": name}
+			for _, column := range columns {
+				value, ok := row.Values[column.Name]
+				if !ok {
+					record[column.Name] = nil
+					continue
+				}
+				record[column.Name] = value
+				if column.Numeric {
+					if number, err := strconv.ParseFloat(value, 64); err == nil {
+						record[column.Name] = number
+					}
+				}
+			}
+			records = append(records, record)
+		}
+	}
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(records)
+}
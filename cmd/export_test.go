@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+
+	"github.com/nanassito/air/pkg/tsdb"
+)
+
+func TestParseTime(t *testing.T) {
+	is := is.New(t)
+
+	ago, err := parseTime("90m")
+	is.NoErr(err)
+	is.True(time.Since(ago) >= 90*time.Minute && time.Since(ago) < 91*time.Minute)
+
+	at, err := parseTime("2023-01-02T15:04")
+	is.NoErr(err)
+	is.Equal(time.Date(2023, 1, 2, 15, 4, 0, 0, time.Local), at)
+
+	at, err = parseTime("2023-01-02")
+	is.NoErr(err)
+	is.Equal(time.Date(2023, 1, 2, 0, 0, 0, 0, time.Local), at)
+
+	at, err = parseTime("2023-01-02T15:04:05Z")
+	is.NoErr(err)
+	is.Equal(time.Date(2023, 1, 2, 15, 4, 5, 0, time.UTC), at.UTC())
+
+	_, err = parseTime("yesterday")
+	is.True(err != nil)
+}
+
+func TestExport(t *testing.T) {
+	is := is.New(t)
+	dir := t.TempDir()
+	store, err := tsdb.Open(dir, 30*24*time.Hour)
+	is.NoErr(err)
+	now := time.Now()
+	is.NoErr(store.Append("esphome/office/mode_state", now.Add(-30*time.Minute), "HEAT"))
+	is.NoErr(store.Append(sensors["office"], now.Add(-20*time.Minute), "19.5"))
+	is.NoErr(store.Append(sensors["kitchen"], now.Add(-20*time.Minute), "21"))
+	is.NoErr(store.Close())
+
+	t.Run("csv", func(t *testing.T) {
+		is := is.New(t)
+		var out bytes.Buffer
+		is.NoErr(export([]string{"-history", dir, "-unit", "office", "-from", "1h"}, &out))
+		records, err := csv.NewReader(&out).ReadAll()
+		is.NoErr(err)
+		is.Equal(3, len(records)) // The header, then one row per change.
+		is.Equal([]string{"time", "unit", "sensor_temp", "unit_temp", "min_temp", "max_temp", "mode", "fan", "target_temp", "decision_score"}, records[0])
+		is.Equal([]string{"office", "", "", "", "", "HEAT", "", "", ""}, records[1][1:])
+		is.Equal([]string{"office", "19.5", "", "", "", "HEAT", "", "", ""}, records[2][1:])
+	})
+
+	t.Run("json", func(t *testing.T) {
+		is := is.New(t)
+		var out bytes.Buffer
+		is.NoErr(export([]string{"-history", dir, "-format", "json", "-from", "1h", "-to", "10m"}, &out))
+		var records []map[string]any
+		is.NoErr(json.Unmarshal(out.Bytes(), &records))
+		is.Equal(3, len(records))
+		is.Equal("kitchen", records[0]["unit"])
+		is.Equal(21.0, records[0]["sensor_temp"])
+		is.Equal(nil, records[0]["mode"])
+		is.Equal("office", records[2]["unit"])
+		is.Equal(19.5, records[2]["sensor_temp"])
+		is.Equal("HEAT", records[2]["mode"])
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		is := is.New(t)
+		var out bytes.Buffer
+		is.True(export([]string{"-history", dir, "-format", "xml"}, &out) != nil)
+		is.True(export([]string{"-history", dir, "-unit", "attic"}, &out) != nil)
+		is.True(export([]string{"-history", dir, "-from", "yesterday"}, &out) != nil)
+		is.True(export([]string{"-history", t.TempDir() + "/missing"}, &out) != nil)
+		is.True(export([]string{}, &out) != nil)
+	})
+}
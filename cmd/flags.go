@@ -0,0 +1,101 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/nanassito/air/pkg/models"
+)
+
+// roomTopics collects repeatable room=topic flags, for the sensors that only some rooms have.
+type roomTopics map[string][]string
+
+func (r roomTopics) String() string {
+	entries := make([]string, 0)
+	for room, topics := range r {
+		for _, topic := range topics {
+			entries = append(entries, room+"="+topic)
+		}
+	}
+	sort.Strings(entries)
+	return strings.Join(entries, ",")
+}
+
+func (r roomTopics) Set(value string) error {
+	room, topic, found := strings.Cut(value, "=")
+	if !found || room == "" || topic == "" {
+		return fmt.Errorf("expected room=topic, got %q", value)
+	}
+	if _, ok := sensors[room]; !ok {
+		return fmt.Errorf("unknown room %q", room)
+	}
+	r[room] = append(r[room], topic)
+	return nil
+}
+
+func roomTopicsFlag(name string, usage string) roomTopics {
+	r := roomTopics{}
+	flag.Var(r, name, usage)
+	return r
+}
+
+// pumpTopics collects repeatable pump=topic flags, for the inputs of an outdoor unit.
+type pumpTopics map[string]string
+
+func (p pumpTopics) String() string {
+	entries := make([]string, 0, len(p))
+	for pump, topic := range p {
+		entries = append(entries, pump+"="+topic)
+	}
+	sort.Strings(entries)
+	return strings.Join(entries, ",")
+}
+
+func (p pumpTopics) Set(value string) error {
+	pump, topic, found := strings.Cut(value, "=")
+	if !found || pump == "" || topic == "" {
+		return fmt.Errorf("expected pump=topic, got %q", value)
+	}
+	if !pumpNames[pump] {
+		return fmt.Errorf("unknown pump %q", pump)
+	}
+	if _, ok := p[pump]; ok {
+		return fmt.Errorf("pump %q given twice", pump)
+	}
+	p[pump] = topic
+	return nil
+}
+
+func pumpTopicsFlag(name string, usage string) pumpTopics {
+	p := pumpTopics{}
+	flag.Var(p, name, usage)
+	return p
+}
+
+// tariffPeriods collects repeatable level=HH:MM-HH:MM flags.
+type tariffPeriods []models.TariffPeriod
+
+func (t *tariffPeriods) String() string {
+	entries := make([]string, 0, len(*t))
+	for _, period := range *t {
+		entries = append(entries, fmt.Sprintf("%s=%s-%s", period.Level, period.Start, period.End))
+	}
+	return strings.Join(entries, ",")
+}
+
+func (t *tariffPeriods) Set(value string) error {
+	period, err := models.ParseTariffPeriod(value)
+	if err != nil {
+		return err
+	}
+	*t = append(*t, period)
+	return nil
+}
+
+func tariffPeriodsFlag(name string, usage string) *tariffPeriods {
+	t := &tariffPeriods{}
+	flag.Var(t, name, usage)
+	return t
+}
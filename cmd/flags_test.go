@@ -0,0 +1,34 @@
+package main
+
+import (
+	"flag"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestRoomTopics(t *testing.T) {
+	is := is.New(t)
+	r := roomTopics{}
+	is.NoErr(r.Set("office=zigbee2mqtt/office/window"))
+	is.NoErr(r.Set("office=zigbee2mqtt/office/door"))
+	is.Equal([]string{"zigbee2mqtt/office/window", "zigbee2mqtt/office/door"}, r["office"])
+	is.Equal("office=zigbee2mqtt/office/door,office=zigbee2mqtt/office/window", r.String())
+
+	is.True(r.Set("attic=zigbee2mqtt/attic/window") != nil) // Unknown room.
+	is.True(r.Set("office") != nil)
+}
+
+func TestPumpTopics(t *testing.T) {
+	is := is.New(t)
+	flags := flag.NewFlagSet("air3", flag.ContinueOnError)
+	p := pumpTopics{}
+	flags.Var(p, "pump-power-meter", "")
+	is.NoErr(flags.Parse([]string{"-pump-power-meter", "multisplit=shellies/multisplit/power", "-pump-power-meter", "living=shellies/living/power"}))
+	is.Equal(pumpTopics{"multisplit": "shellies/multisplit/power", "living": "shellies/living/power"}, p)
+	is.Equal("living=shellies/living/power,multisplit=shellies/multisplit/power", p.String())
+
+	is.True(p.Set("living=shellies/other/power") != nil) // One meter per pump.
+	is.True(p.Set("garage=shellies/garage/power") != nil)
+	is.True(p.Set("living") != nil)
+}
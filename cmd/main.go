@@ -1,63 +1,188 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
+	"github.com/nanassito/air/pkg/api"
+	"github.com/nanassito/air/pkg/discovery"
 	"github.com/nanassito/air/pkg/logic"
 	"github.com/nanassito/air/pkg/models"
 	"github.com/nanassito/air/pkg/mqtt"
+	"github.com/nanassito/air/pkg/tsdb"
 	"github.com/nanassito/air/pkg/utils"
 )
 
 var (
-	server = flag.String("mqtt", "tcp://mqtt.epa.jaminais.fr:31883", "Address of the mqtt server.")
-	L      = utils.Logger
+	server    = flag.String("mqtt", "tcp://mqtt.epa.jaminais.fr:31883", "Address of the mqtt server.")
+	listen    = flag.String("http", "", "Address to serve the status api and dashboard on, e.g. :8080. Disabled if empty.")
+	prefix    = flag.String("discovery-prefix", "homeassistant", "Home Assistant mqtt discovery prefix.")
+	meter     = flag.String("power-meter", "", "Mqtt topic of the whole house power meter, disables peak limiting if empty.")
+	limit     = flag.Float64("power-limit", 9000, "Whole house power (in W) above which units get shed.")
+	outdoor   = flag.String("outdoor-sensor", "", "Mqtt topic of the outdoor temperature sensor, optional.")
+	history   = flag.String("history", "", "Directory to persist the sensor and value histories in, kept in memory only if empty.")
+	retention = flag.Duration("history-retention", 30*24*time.Hour, "How long the persisted histories are kept.")
+	windows   = roomTopicsFlag("window-sensor", "Contact sensor of a window, as room=topic. Can be repeated.")
+	occupancy = roomTopicsFlag("occupancy-sensor", "Zigbee motion or presence sensor, as room=topic. Can be repeated.")
+	presence  = roomTopicsFlag("presence", "Plain presence state such as a forwarded Home Assistant person, as room=topic. Can be repeated.")
+	// Without any period nor feed, the tariff is always normal and leaves the comfort ranges alone.
+	periods    = tariffPeriodsFlag("tariff-period", "Time of day priced at a tariff level, as level=HH:MM-HH:MM, e.g. cheap=22:00-06:00. Can be repeated.")
+	tariffFeed = flag.String("tariff-feed", "", "Mqtt topic publishing the current tariff level (cheap, normal or peak), optional.")
+	tariffBand = flag.Float64("tariff-band", 1, "How far (in °C) the comfort ranges shift with the tariff.")
+	pumpMeters = pumpTopicsFlag("pump-power-meter", "Power meter of an outdoor unit, as pump=topic, for its runtime and energy accounting. Can be repeated.")
+	logFormat  = flag.String("log-format", "text", "Format of the logs, text or json.")
+	logLevels  = flag.String("log-level", "info", "Log levels, optionally per subsystem, e.g. info,mqtt=warn,logic=debug.")
+	L          = utils.Logger
 )
 
+// sensors is the topic of the sensor measuring the temperature of the room of each unit.
+var sensors = map[string]string{
+	"office":  "zigbee2mqtt/server/device/office/air",
+	"kitchen": "zigbee2mqtt/server/device/kitchen/followme",
+	"parent":  "zigbee2mqtt/server/device/parent/followme",
+	"zaya":    "zigbee2mqtt/server/sonoff2 in Zaya's bedroom",
+	"living":  "zigbee2mqtt/server/device/living/followme",
+}
+
+// pumpNames are the outdoor units the flags can refer to.
+var pumpNames = map[string]bool{"multisplit": true, "living": true}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		if err := export(os.Args[2:], os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
 	flag.Parse()
+	if err := utils.SetFormat(*logFormat); err != nil {
+		L.Error("Invalid log format", "err", err)
+		os.Exit(1)
+	}
+	if err := utils.SetLevels(*logLevels); err != nil {
+		L.Error("Invalid log levels", "err", err)
+		os.Exit(1)
+	}
+	discovery.Prefix = *prefix
+	if *history != "" {
+		store, err := tsdb.Open(*history, *retention)
+		if err != nil {
+			L.Error("Failed to open the history, keeping it in memory only", "err", err, "dir", *history)
+		} else {
+			mqtt.History = store
+		}
+	}
 	mqttClient := mqtt.MustNewMqttClient(*server)
+	mqtt.ListenForLogLevels(mqttClient)
 
 	pumps := []*models.Pump{
 		{
+			Name:        "multisplit",
+			MinModeHold: 1 * time.Hour,
+			Protection: models.CompressorProtection{
+				MinOn:            10 * time.Minute,
+				MinOff:           5 * time.Minute,
+				MaxStartsPerHour: 3,
+			},
+			StartInterval: 2 * time.Minute,
 			Units: []*models.Hvac{
-				models.NewHvacWithDefaultTopics(
-					mqttClient,
-					"office",
-					"zigbee2mqtt/server/device/office/air",
-				),
-				models.NewHvacWithDefaultTopics(
-					mqttClient,
-					"kitchen",
-					"zigbee2mqtt/server/device/kitchen/followme",
-				),
-				models.NewHvacWithDefaultTopics(
-					mqttClient,
-					"parent",
-					"zigbee2mqtt/server/device/parent/followme",
-				),
-				models.NewHvacWithDefaultTopics(
-					mqttClient,
-					"zaya",
-					"zigbee2mqtt/server/sonoff2 in Zaya's bedroom",
-				),
+				models.NewHvacWithDefaultTopics(mqttClient, "office", sensors["office"]),
+				models.NewHvacWithDefaultTopics(mqttClient, "kitchen", sensors["kitchen"]),
+				models.NewHvacWithDefaultTopics(mqttClient, "parent", sensors["parent"]),
+				models.NewHvacWithDefaultTopics(mqttClient, "zaya", sensors["zaya"]),
 			},
 		},
 		{
+			Name: "living",
+			Protection: models.CompressorProtection{
+				MinOn:            10 * time.Minute,
+				MinOff:           5 * time.Minute,
+				MaxStartsPerHour: 3,
+			},
 			Units: []*models.Hvac{
-				models.NewHvacWithDefaultTopics(
-					mqttClient,
-					"living",
-					"zigbee2mqtt/server/device/living/followme",
-				),
+				models.NewHvacWithDefaultTopics(mqttClient, "living", sensors["living"]),
 			},
 		},
 	}
-	for range time.Tick(30 * time.Second) {
-		L.Info("Autopilot run.")
-		for _, pump := range pumps {
-			logic.TunePump(pump)
+	for _, pump := range pumps {
+		if topic, ok := pumpMeters[pump.Name]; ok {
+			pump.PowerMeter = mqtt.NewJsonPowerMeter(mqttClient, topic)
+		}
+		pump.PublishRuntimeDiscovery(mqttClient)
+		for _, hvac := range pump.Units {
+			hvac.WithWindowSensors(windows[hvac.Name]...)
+			hvac.WithOccupancySensors(occupancy[hvac.Name]...)
+			hvac.WithPresence(presence[hvac.Name]...)
+			logic.PublishStrategySelect(mqttClient, hvac)
+		}
+	}
+	tariff := models.NewTariff(mqttClient, *tariffFeed, *tariffBand, *periods...)
+	site := models.NewSite(pumps, tariff, models.NewAway(mqttClient, 12, 30))
+	if *outdoor != "" {
+		site.SetOutdoorSensor(mqtt.NewJsonTemperatureSensor(mqttClient, *outdoor))
+	}
+	if *meter != "" {
+		site.PowerLimit = models.NewPowerLimit(mqttClient, *meter, *limit, 1000)
+	}
+
+	logic.RepublishOnBirth(mqttClient, site)
+	var httpServer *http.Server
+	if *listen != "" {
+		apiServer := api.NewServer(site)
+		httpServer = &http.Server{Addr: *listen, Handler: apiServer}
+		httpServer.RegisterOnShutdown(apiServer.Close)
+		go func() {
+			L.Info("Serving the status api.", "address", *listen)
+			if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				L.Error("Status api stopped", "err", err)
+			}
+		}()
+	}
+
+	shutdown, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+	// The signal only stops the loop: a run in progress gets to finish its commands, within the grace period.
+	ctx, cancel := utils.WithGracePeriod(shutdown, 10*time.Second)
+	defer cancel()
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+	for running := true; running; {
+		select {
+		case <-shutdown.Done():
+			running = false
+		case job := <-utils.MainLoop.Jobs():
+			job()
+		case <-ticker.C:
+			L.Info("Autopilot run.")
+			logic.TuneSite(ctx, site)
+			for _, pump := range site.Pumps {
+				pump.PublishRuntime(mqttClient)
+			}
+		}
+	}
+
+	L.Info("Shutting down.")
+	for _, hvac := range site.Units() {
+		hvac.Actions.CancelAll()
+	}
+	if httpServer != nil {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := httpServer.Shutdown(shutdownCtx); err != nil {
+			L.Error("Failed to stop the status api", "err", err)
+		}
+	}
+	mqtt.Disconnect(mqttClient)
+	if mqtt.History != nil {
+		if err := mqtt.History.Close(); err != nil {
+			L.Error("Failed to close the history", "err", err)
 		}
 	}
 }
@@ -0,0 +1,206 @@
+package api
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/nanassito/air/pkg/models"
+	"github.com/nanassito/air/pkg/utils"
+)
+
+var L = utils.NewLogger("api")
+
+//go:embed index.html
+var dashboard embed.FS
+
+type Server struct {
+	mux  *http.ServeMux
+	site *models.Site
+	// The models are only read and changed from the main loop.
+	loop *utils.Loop
+	done chan struct{}
+}
+
+// Close ends the live event streams, which would otherwise hold a graceful shutdown up.
+func (s *Server) Close() {
+	close(s.done)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) status(w http.ResponseWriter, r *http.Request) {
+	var status models.SiteStatus
+	if err := s.loop.Wait(r.Context(), func() { status = s.site.Status() }); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	writeJson(w, status)
+}
+
+type unitHistory struct {
+	SensorTemp any `json:"sensor_temp"`
+	UnitTemp   any `json:"unit_temp"`
+}
+
+// history serves the temperatures of a unit over the last `since` (1h by default), for the dashboard chart.
+func (s *Server) history(w http.ResponseWriter, r *http.Request) {
+	hvac := s.site.Unit(r.URL.Query().Get("unit"))
+	if hvac == nil {
+		http.Error(w, "unknown unit", http.StatusNotFound)
+		return
+	}
+	since := time.Hour
+	if value := r.URL.Query().Get("since"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		since = d
+	}
+	from, to := time.Now().Add(-since), time.Now()
+	var history unitHistory
+	var err error
+	waitErr := s.loop.Wait(r.Context(), func() {
+		if history.SensorTemp, err = hvac.AutoPilot.Sensors.Air.History(from, to); err != nil {
+			return
+		}
+		history.UnitTemp, err = hvac.AutoPilot.Sensors.Unit.History(from, to)
+	})
+	if waitErr != nil {
+		http.Error(w, waitErr.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJson(w, history)
+}
+
+// control changes the setpoints or the autopilot of a unit, the same way Home Assistant does over mqtt. Every field
+// is validated before any is applied, so a request is either applied as a whole or not at all.
+func (s *Server) control(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	hvac := s.site.Unit(r.FormValue("unit"))
+	if hvac == nil {
+		http.Error(w, "unknown unit", http.StatusNotFound)
+		return
+	}
+	changes := make([]func(), 0)
+	for _, field := range []string{"min_temp", "max_temp", "autopilot"} {
+		value := r.FormValue(field)
+		if value == "" {
+			continue
+		}
+		change, err := parseControl(hvac, field, []byte(value))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid %s: %s", field, err), http.StatusBadRequest)
+			return
+		}
+		L.Info("Command from the dashboard", "hvac", hvac.Name, "field", field, "value", value)
+		changes = append(changes, change)
+	}
+	var status models.HvacStatus
+	err := s.loop.Wait(r.Context(), func() {
+		for _, change := range changes {
+			change()
+		}
+		status = hvac.Status()
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	writeJson(w, status)
+}
+
+// parseControl validates a field of a control request, and returns how to apply it.
+func parseControl(hvac *models.Hvac, field string, payload []byte) (func(), error) {
+	switch field {
+	case "min_temp":
+		temp, err := hvac.AutoPilot.MinTemp.Parse(payload)
+		return func() { hvac.AutoPilot.MinTemp.Set(temp) }, err
+	case "max_temp":
+		temp, err := hvac.AutoPilot.MaxTemp.Parse(payload)
+		return func() { hvac.AutoPilot.MaxTemp.Set(temp) }, err
+	case "autopilot":
+		enabled, err := hvac.AutoPilot.Enabled.Parse(payload)
+		return func() { hvac.AutoPilot.Enabled.Set(enabled) }, err
+	default:
+		return nil, fmt.Errorf("unknown field: %q", field)
+	}
+}
+
+// logLevels serves the log levels, and changes them on POST, e.g. levels=info,mqtt=debug or levels=mqtt=default.
+func (s *Server) logLevels(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		levels := r.FormValue("levels")
+		if err := utils.SetLevels(levels); err != nil {
+			http.Error(w, fmt.Sprintf("invalid levels: %s", err), http.StatusBadRequest)
+			return
+		}
+		L.Info("Changed log levels", "levels", utils.Levels())
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprintln(w, utils.Levels())
+}
+
+// sameOrigin refuses the requests changing something that a browser sent on behalf of another site, so a page
+// visited on the LAN can't drive the units. Clients that aren't browsers send none of these headers.
+func sameOrigin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			next(w, r)
+			return
+		}
+		allowed := true
+		if site := r.Header.Get("Sec-Fetch-Site"); site != "" {
+			allowed = site == "same-origin" || site == "none"
+		} else if origin := r.Header.Get("Origin"); origin != "" {
+			u, err := url.Parse(origin)
+			allowed = err == nil && u.Host == r.Host
+		} else if referer := r.Header.Get("Referer"); referer != "" {
+			u, err := url.Parse(referer)
+			allowed = err == nil && u.Host == r.Host
+		}
+		if !allowed {
+			L.Warn("Refusing a cross-origin request", "path", r.URL.Path, "origin", r.Header.Get("Origin"))
+			http.Error(w, "cross-origin request", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func writeJson(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		L.Error("Failed to write the http response", "err", err)
+	}
+}
+
+func NewServer(site *models.Site) *Server {
+	s := Server{
+		mux:  http.NewServeMux(),
+		site: site,
+		loop: utils.MainLoop,
+		done: make(chan struct{}),
+	}
+	s.mux.HandleFunc("/status", s.status)
+	s.mux.HandleFunc("/history", s.history)
+	s.mux.HandleFunc("/control", sameOrigin(s.control))
+	s.mux.HandleFunc("/events", s.events)
+	s.mux.HandleFunc("/log_levels", sameOrigin(s.logLevels))
+	s.mux.Handle("/", http.FileServer(http.FS(dashboard)))
+	return &s
+}
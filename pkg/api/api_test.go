@@ -0,0 +1,173 @@
+package api_test
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+
+	"github.com/nanassito/air/pkg/api"
+	"github.com/nanassito/air/pkg/mocks"
+	"github.com/nanassito/air/pkg/models"
+	"github.com/nanassito/air/pkg/utils"
+)
+
+// runMainLoop stands in for the main loop of air3 for the duration of the test.
+func runMainLoop(t *testing.T) {
+	done := make(chan struct{})
+	t.Cleanup(func() { close(done) })
+	go func() {
+		for {
+			select {
+			case job := <-utils.MainLoop.Jobs():
+				job()
+			case <-done:
+				return
+			}
+		}
+	}()
+}
+
+func TestDashboard(t *testing.T) {
+	is := is.New(t)
+	mqttClient := mocks.NewMockMqtt()
+	roomTemp := mocks.NewMockTemperatureSensor(mqttClient, "sensor")
+	hvac := models.NewHvacWithDefaultTopics(mqttClient, "room", roomTemp.Topic())
+	site := models.NewSite([]*models.Pump{{Units: []*models.Hvac{hvac}}}, models.NewTariff(mqttClient, "", 1), models.NewAway(mqttClient, 12, 30))
+	server := api.NewServer(site)
+	runMainLoop(t) // Like air3, once everything is set up.
+	roomTemp.Set(21)
+
+	t.Run("index", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		is.Equal(http.StatusOK, w.Code)
+		is.True(strings.Contains(w.Body.String(), "<title>air3</title>"))
+	})
+
+	t.Run("status", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/status", nil))
+		is.Equal(http.StatusOK, w.Code)
+		is.True(strings.Contains(w.Body.String(), `"thermal_model"`))
+	})
+
+	t.Run("history", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/history?unit=room", nil))
+		is.Equal(http.StatusOK, w.Code)
+		is.True(strings.Contains(w.Body.String(), `"value":21`))
+	})
+
+	t.Run("control", func(t *testing.T) {
+		form := url.Values{"unit": {"room"}, "min_temp": {"20.5"}, "max_temp": {"26"}, "autopilot": {"off"}}
+		r := httptest.NewRequest(http.MethodPost, "/control", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		is.Equal(http.StatusOK, w.Code)
+		is.Equal(20.5, hvac.AutoPilot.MinTemp.Get())
+		is.Equal(26.0, hvac.AutoPilot.MaxTemp.Get())
+		is.Equal(false, hvac.AutoPilot.Enabled.Get())
+	})
+
+	t.Run("invalid control", func(t *testing.T) {
+		form := url.Values{"unit": {"room"}, "min_temp": {"19"}, "max_temp": {"10"}}
+		r := httptest.NewRequest(http.MethodPost, "/control", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		is.Equal(http.StatusBadRequest, w.Code)
+		is.Equal(20.5, hvac.AutoPilot.MinTemp.Get()) // Nothing applied.
+		is.Equal(26.0, hvac.AutoPilot.MaxTemp.Get())
+	})
+
+	t.Run("cross-origin control", func(t *testing.T) {
+		form := url.Values{"unit": {"room"}, "autopilot": {"auto"}}
+		for _, header := range [][2]string{
+			{"Sec-Fetch-Site", "cross-site"},
+			{"Origin", "http://evil.example"},
+			{"Referer", "http://evil.example/page.html"},
+		} {
+			r := httptest.NewRequest(http.MethodPost, "/control", strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			r.Header.Set(header[0], header[1])
+			w := httptest.NewRecorder()
+			server.ServeHTTP(w, r)
+			is.Equal(http.StatusForbidden, w.Code)
+			is.Equal(false, hvac.AutoPilot.Enabled.Get())
+		}
+
+		r := httptest.NewRequest(http.MethodPost, "/control", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.Header.Set("Origin", "http://"+r.Host)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		is.Equal(http.StatusOK, w.Code)
+		is.Equal(true, hvac.AutoPilot.Enabled.Get())
+	})
+}
+
+func TestLogLevels(t *testing.T) {
+	is := is.New(t)
+	defer utils.SetLevels("info,mqtt=default")
+	mqttClient := mocks.NewMockMqtt()
+	site := models.NewSite([]*models.Pump{}, models.NewTariff(mqttClient, "", 1), models.NewAway(mqttClient, 12, 30))
+	server := api.NewServer(site)
+	post := func(levels string, origin string) *httptest.ResponseRecorder {
+		form := url.Values{"levels": {levels}}
+		r := httptest.NewRequest(http.MethodPost, "/log_levels", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		return w
+	}
+
+	w := post("warn,mqtt=debug", "")
+	is.Equal(http.StatusOK, w.Code)
+	is.Equal("WARN,mqtt=DEBUG\n", w.Body.String())
+
+	is.Equal(http.StatusBadRequest, post("info,attic=debug", "").Code)
+	is.Equal(http.StatusForbidden, post("info", "http://evil.example").Code)
+	is.Equal("WARN,mqtt=DEBUG", utils.Levels())
+
+	is.Equal(http.StatusOK, post("mqtt=default", "http://example.com").Code)
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/log_levels", nil))
+	is.Equal("WARN\n", w.Body.String())
+}
+
+func TestEvents(t *testing.T) {
+	is := is.New(t)
+	mqttClient := mocks.NewMockMqtt()
+	room := models.NewHvacWithDefaultTopics(mqttClient, "room", mocks.NewMockTemperatureSensor(mqttClient, "room_sensor").Topic())
+	other := models.NewHvacWithDefaultTopics(mqttClient, "other", mocks.NewMockTemperatureSensor(mqttClient, "other_sensor").Topic())
+	site := models.NewSite([]*models.Pump{{Units: []*models.Hvac{room, other}}}, models.NewTariff(mqttClient, "", 1), models.NewAway(mqttClient, 12, 30))
+	apiServer := api.NewServer(site)
+	server := httptest.NewServer(apiServer)
+	defer server.Close()
+	defer apiServer.Close()
+
+	response, err := http.Get(server.URL + "/events?unit=room")
+	is.NoErr(err)
+	defer response.Body.Close()
+	is.Equal("text/event-stream", response.Header.Get("Content-Type"))
+
+	other.Decide("Ignored")
+	room.Decide("Heating")
+	reader := bufio.NewReader(response.Body)
+	event, err := reader.ReadString('\n')
+	is.NoErr(err)
+	is.Equal("event: decision\n", event)
+	data, err := reader.ReadString('\n')
+	is.NoErr(err)
+	is.True(strings.Contains(data, `"unit":"room"`))
+	is.True(strings.Contains(data, `"value":"Heating"`))
+}
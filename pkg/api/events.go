@@ -0,0 +1,62 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/nanassito/air/pkg/events"
+)
+
+// How often a comment is sent to keep idle connections from being closed by proxies.
+const keepAlive = 15 * time.Second
+
+// events streams the live events as Server-Sent Events, optionally only those of the given units and types.
+func (s *Server) events(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	units := map[string]bool{}
+	for _, unit := range r.URL.Query()["unit"] {
+		units[unit] = true
+	}
+	types := map[string]bool{}
+	for _, t := range r.URL.Query()["type"] {
+		types[t] = true
+	}
+
+	stream, cancel := events.Default.Subscribe()
+	defer cancel()
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ticker := time.NewTicker(keepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.done:
+			return
+		case <-ticker.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case event := <-stream:
+			if len(units) > 0 && !units[event.Unit] || len(types) > 0 && !types[event.Type] {
+				continue
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				L.Error("Failed to serialize an event", "err", err, "event", event)
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+		}
+		flusher.Flush()
+	}
+}
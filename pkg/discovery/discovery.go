@@ -0,0 +1,163 @@
+// Package discovery declares air3 entities to Home Assistant through mqtt discovery.
+package discovery
+
+import (
+	"encoding/json"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+
+	"github.com/nanassito/air/pkg/utils"
+)
+
+var (
+	L = utils.NewLogger("discovery")
+	// Prefix is the discovery prefix configured in Home Assistant.
+	Prefix = "homeassistant"
+)
+
+// StatusTopic is where Home Assistant publishes its birth and last will messages.
+func StatusTopic() string {
+	return Prefix + "/status"
+}
+
+type Config interface {
+	Component() string
+}
+
+type Device struct {
+	Identifiers  string `json:"identifiers"`
+	Name         string `json:"name,omitempty"`
+	Model        string `json:"model,omitempty"`
+	Manufacturer string `json:"manufacturer,omitempty"`
+}
+
+// Entity holds the attributes shared by every component.
+type Entity struct {
+	Name              string `json:"name"`
+	UniqueID          string `json:"unique_id"`
+	Icon              string `json:"icon,omitempty"`
+	EntityCategory    string `json:"entity_category,omitempty"`
+	AvailabilityTopic string `json:"availability_topic,omitempty"`
+	Device            Device `json:"device"`
+}
+
+type Climate struct {
+	Entity
+	MinTemp                     float64  `json:"min_temp"`
+	MaxTemp                     float64  `json:"max_temp"`
+	Precision                   float64  `json:"precision"`
+	TempStep                    float64  `json:"temp_step"`
+	TemperatureUnit             string   `json:"temperature_unit"`
+	TemperatureHighCommandTopic string   `json:"temperature_high_command_topic"`
+	TemperatureHighStateTopic   string   `json:"temperature_high_state_topic"`
+	TemperatureLowCommandTopic  string   `json:"temperature_low_command_topic"`
+	TemperatureLowStateTopic    string   `json:"temperature_low_state_topic"`
+	CurrentTemperatureTopic     string   `json:"current_temperature_topic"`
+	CurrentTemperatureTemplate  string   `json:"current_temperature_template,omitempty"`
+	ActionTopic                 string   `json:"action_topic,omitempty"`
+	ModeCommandTopic            string   `json:"mode_command_topic"`
+	ModeStateTopic              string   `json:"mode_state_topic"`
+	Modes                       []string `json:"modes"`
+	FanModeCommandTopic         string   `json:"fan_mode_command_topic,omitempty"`
+	FanModeStateTopic           string   `json:"fan_mode_state_topic,omitempty"`
+	PresetModes                 []string `json:"preset_modes,omitempty"`
+	PresetModeCommandTopic      string   `json:"preset_mode_command_topic,omitempty"`
+	PresetModeStateTopic        string   `json:"preset_mode_state_topic,omitempty"`
+}
+
+func (Climate) Component() string { return "climate" }
+
+type Sensor struct {
+	Entity
+	StateTopic        string `json:"state_topic"`
+	ValueTemplate     string `json:"value_template,omitempty"`
+	UnitOfMeasurement string `json:"unit_of_measurement,omitempty"`
+	DeviceClass       string `json:"device_class,omitempty"`
+	StateClass        string `json:"state_class,omitempty"`
+}
+
+func (Sensor) Component() string { return "sensor" }
+
+type BinarySensor struct {
+	Entity
+	StateTopic    string `json:"state_topic"`
+	ValueTemplate string `json:"value_template,omitempty"`
+	PayloadOn     string `json:"payload_on,omitempty"`
+	PayloadOff    string `json:"payload_off,omitempty"`
+	DeviceClass   string `json:"device_class,omitempty"`
+}
+
+func (BinarySensor) Component() string { return "binary_sensor" }
+
+type Number struct {
+	Entity
+	CommandTopic      string  `json:"command_topic"`
+	StateTopic        string  `json:"state_topic"`
+	Min               float64 `json:"min"`
+	Max               float64 `json:"max"`
+	Step              float64 `json:"step"`
+	UnitOfMeasurement string  `json:"unit_of_measurement,omitempty"`
+	Mode              string  `json:"mode,omitempty"`
+}
+
+func (Number) Component() string { return "number" }
+
+type Select struct {
+	Entity
+	CommandTopic string   `json:"command_topic"`
+	StateTopic   string   `json:"state_topic"`
+	Options      []string `json:"options"`
+}
+
+func (Select) Component() string { return "select" }
+
+type Switch struct {
+	Entity
+	CommandTopic string `json:"command_topic"`
+	StateTopic   string `json:"state_topic"`
+	PayloadOn    string `json:"payload_on,omitempty"`
+	PayloadOff   string `json:"payload_off,omitempty"`
+}
+
+func (Switch) Component() string { return "switch" }
+
+type Text struct {
+	Entity
+	CommandTopic string `json:"command_topic"`
+	StateTopic   string `json:"state_topic"`
+	Pattern      string `json:"pattern,omitempty"`
+}
+
+func (Text) Component() string { return "text" }
+
+type Button struct {
+	Entity
+	CommandTopic string `json:"command_topic"`
+	PayloadPress string `json:"payload_press,omitempty"`
+}
+
+func (Button) Component() string { return "button" }
+
+func Topic(config Config, objectID string) string {
+	return Prefix + "/" + config.Component() + "/air3/" + objectID + "/config"
+}
+
+// Publish declares the entity to Home Assistant, retained so it survives a restart of Home Assistant.
+func Publish(client paho.Client, objectID string, config Config) {
+	payload, err := json.Marshal(config)
+	if err != nil {
+		L.Error("Failed to build the discovery config", "err", err, "objectID", objectID)
+		return
+	}
+	client.Publish(Topic(config, objectID), 0, true, payload)
+}
+
+// OnBirth calls f every time Home Assistant announces that it is (back) online.
+func OnBirth(client paho.Client, f func()) {
+	client.Subscribe(StatusTopic(), 0, func(c paho.Client, m paho.Message) {
+		L.Debug("Received", "topic", m.Topic(), "payload", m.Payload())
+		if string(m.Payload()) == "online" {
+			f()
+		}
+	})
+}
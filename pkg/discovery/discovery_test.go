@@ -0,0 +1,89 @@
+package discovery_test
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/matryer/is"
+
+	"github.com/nanassito/air/pkg/discovery"
+	"github.com/nanassito/air/pkg/mocks"
+)
+
+var update = flag.Bool("update", false, "Update the golden files.")
+
+var entity = discovery.Entity{
+	Name:              "Thermostat",
+	UniqueID:          "zaya_thermostat",
+	Icon:              "mdi:robot",
+	AvailabilityTopic: "air3/status",
+	Device: discovery.Device{
+		Identifiers:  "zaya",
+		Name:         "Zaya's bedroom",
+		Model:        "air3",
+		Manufacturer: "Dorian",
+	},
+}
+
+func TestGolden(t *testing.T) {
+	for _, config := range []discovery.Config{
+		discovery.Climate{
+			Entity:                      entity,
+			MinTemp:                     17,
+			MaxTemp:                     33,
+			Precision:                   0.5,
+			TempStep:                    0.5,
+			TemperatureUnit:             "C",
+			TemperatureHighCommandTopic: "air3/zaya/autopilot/maxTemp/command",
+			TemperatureHighStateTopic:   "air3/zaya/autopilot/maxTemp/state",
+			TemperatureLowCommandTopic:  "air3/zaya/autopilot/minTemp/command",
+			TemperatureLowStateTopic:    "air3/zaya/autopilot/minTemp/state",
+			CurrentTemperatureTopic:     "zigbee2mqtt/server/sonoff2 in Zaya's bedroom",
+			CurrentTemperatureTemplate:  "{{ value_json.temperature }}",
+			ModeCommandTopic:            "air3/zaya/autopilot/mode/command",
+			ModeStateTopic:              "air3/zaya/autopilot/mode/state",
+			Modes:                       []string{"off", "auto"},
+		},
+		discovery.Sensor{Entity: entity, StateTopic: "air3/zaya/score", StateClass: "measurement"},
+		discovery.BinarySensor{Entity: entity, StateTopic: "air3/zaya/problem", PayloadOn: "ON", PayloadOff: "OFF", DeviceClass: "problem"},
+		discovery.Number{Entity: entity, CommandTopic: "air3/zaya/number/command", StateTopic: "air3/zaya/number/state", Min: 0, Max: 10, Step: 0.5},
+		discovery.Select{Entity: entity, CommandTopic: "air3/zaya/select/command", StateTopic: "air3/zaya/select/state", Options: []string{"a", "b"}},
+		discovery.Switch{Entity: entity, CommandTopic: "air3/zaya/switch/command", StateTopic: "air3/zaya/switch/state", PayloadOn: "ON", PayloadOff: "OFF"},
+		discovery.Button{Entity: entity, CommandTopic: "air3/zaya/button/command", PayloadPress: "PRESS"},
+		discovery.Text{Entity: entity, CommandTopic: "air3/zaya/text/command", StateTopic: "air3/zaya/text/state"},
+	} {
+		t.Run(config.Component(), func(t *testing.T) {
+			is := is.New(t)
+			got, err := json.MarshalIndent(config, "", "  ")
+			is.NoErr(err)
+			golden := filepath.Join("testdata", config.Component()+".golden.json")
+			if *update {
+				is.NoErr(os.WriteFile(golden, got, 0644))
+			}
+			want, err := os.ReadFile(golden)
+			is.NoErr(err)
+			is.Equal(string(want), string(got))
+		})
+	}
+}
+
+func TestPublish(t *testing.T) {
+	is := is.New(t)
+	mqttClient := mocks.NewMockMqtt()
+	discovery.Prefix = "custom"
+	defer func() { discovery.Prefix = "homeassistant" }()
+
+	var payload []byte
+	mqttClient.Subscribe("custom/select/air3/zaya_strategy/config", 0, func(c paho.Client, m paho.Message) {
+		payload = m.Payload()
+	})
+	discovery.Publish(mqttClient, "zaya_strategy", discovery.Select{Entity: entity, Options: []string{"score"}})
+
+	parsed := map[string]any{}
+	is.NoErr(json.Unmarshal(payload, &parsed))
+	is.Equal("Zaya's bedroom", parsed["device"].(map[string]any)["name"])
+}
@@ -0,0 +1,69 @@
+// Package events fans out what happens in air3 to live subscribers, such as the dashboard.
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	TypeSensor   = "sensor"
+	TypeCommand  = "command"
+	TypeAck      = "ack"
+	TypeNack     = "nack"
+	TypeState    = "state"
+	TypeMode     = "mode"
+	TypeDecision = "decision"
+)
+
+type Event struct {
+	Time  time.Time `json:"time"`
+	Type  string    `json:"type"`
+	Unit  string    `json:"unit,omitempty"`
+	Name  string    `json:"name,omitempty"`
+	Value any       `json:"value"`
+}
+
+// Bus delivers events to every subscriber. Subscribers that fall behind miss events rather than slowing
+// the autopilot down.
+type Bus struct {
+	mu          sync.Mutex
+	subscribers map[chan Event]struct{}
+}
+
+func NewBus() *Bus {
+	return &Bus{subscribers: map[chan Event]struct{}{}}
+}
+
+// Default is the bus everything publishes to.
+var Default = NewBus()
+
+func (b *Bus) Publish(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for subscriber := range b.subscribers {
+		select {
+		case subscriber <- event:
+		default:
+		}
+	}
+}
+
+// Subscribe returns the events published from now on, until cancel is called.
+func (b *Bus) Subscribe() (events <-chan Event, cancel func()) {
+	subscriber := make(chan Event, 64)
+	b.mu.Lock()
+	b.subscribers[subscriber] = struct{}{}
+	b.mu.Unlock()
+	return subscriber, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subscribers[subscriber]; ok {
+			delete(b.subscribers, subscriber)
+			close(subscriber)
+		}
+	}
+}
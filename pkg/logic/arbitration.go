@@ -0,0 +1,105 @@
+package logic
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/golang-collections/collections/set"
+
+	"github.com/nanassito/air/pkg/models"
+)
+
+// unitDemand returns how far (in °C) the room is past the threshold at which it would start heating or cooling.
+func unitDemand(hvac *models.Hvac) (heat float64, cool float64) {
+	if !hvac.AutoPilot.Enabled.Get() {
+		return 0, 0
+	}
+	current, err := hvac.AutoPilot.Sensors.Air.Get()
+	if err != nil {
+		return 0, 0
+	}
+	if hvac.AutoPilot.MinTemp.IsReady() {
+		heat = math.Max(0, hvac.DesiredMin()+1-current)
+	}
+	if hvac.AutoPilot.MaxTemp.IsReady() {
+		cool = math.Max(0, current-hvac.DesiredMax()+1)
+	}
+	return heat, cool
+}
+
+// pumpDemand sums the demand of every unit, only considering priority units if any of them needs something.
+func pumpDemand(pump *models.Pump) (heat float64, cool float64) {
+	for _, priorityOnly := range []bool{true, false} {
+		heat, cool = 0, 0
+		for _, hvac := range pump.Units {
+			if priorityOnly && !pump.IsPriority(hvac) {
+				continue
+			}
+			h, c := unitDemand(hvac)
+			heat += h
+			cool += c
+		}
+		if heat > 0 || cool > 0 {
+			return heat, cool
+		}
+	}
+	return heat, cool
+}
+
+func runningMode(pump *models.Pump) string {
+	for _, hvac := range pump.Units {
+		if mode := hvac.Mode.Get(); mode == "HEAT" || mode == "COOL" {
+			return mode
+		}
+	}
+	return "OFF"
+}
+
+// Arbitrate decides which mode the shared pump should run in and returns the modes the units are allowed to use.
+func Arbitrate(ctx context.Context, pump *models.Pump) *set.Set {
+	running := runningMode(pump)
+	if running != pump.Arbitration.Mode {
+		pump.Arbitration.Since = time.Now()
+	}
+	heat, cool := pumpDemand(pump)
+	wanted := "OFF"
+	if heat > cool {
+		wanted = "HEAT"
+	} else if cool > heat {
+		wanted = "COOL"
+	}
+
+	decision := running
+	switch {
+	case running == "OFF" && wanted == "OFF":
+		pump.Arbitration.Reason = "no demand"
+	case running == "OFF":
+		decision = wanted
+		pump.Arbitration.Reason = fmt.Sprintf("pump is idle, demand heat=%.1f cool=%.1f", heat, cool)
+	case wanted == "OFF" || wanted == running:
+		pump.Arbitration.Reason = fmt.Sprintf("keeping %s, demand heat=%.1f cool=%.1f", running, heat, cool)
+	case time.Since(pump.Arbitration.Since) < pump.MinModeHold:
+		pump.Arbitration.Reason = fmt.Sprintf("%s is wanted but %s is held for %s", wanted, running, pump.MinModeHold)
+	default:
+		decision = wanted
+		pump.Arbitration.Reason = fmt.Sprintf("switching from %s to %s, demand heat=%.1f cool=%.1f", running, wanted, heat, cool)
+		for _, hvac := range pump.Units {
+			if hvac.Mode.Get() == running && hvac.AutoPilot.Enabled.Get() {
+				GetStrategy(hvac).Stop(ctx, hvac, pump)
+			}
+		}
+		if runningMode(pump) != "OFF" {
+			decision = running
+			pump.Arbitration.Reason = fmt.Sprintf("%s is wanted but a unit is still in %s", wanted, running)
+		}
+	}
+	pump.Arbitration.Mode = runningMode(pump)
+	L.Info("Pump arbitration", "pump", pump.Name, "decision", decision, "reason", pump.Arbitration.Reason)
+
+	if decision == "OFF" {
+		return set.New("OFF", "HEAT", "COOL", "FAN_ONLY")
+	}
+	return set.New("OFF", decision)
+}
@@ -0,0 +1,84 @@
+package logic
+
+import (
+	"context"
+
+	"github.com/nanassito/air/pkg/models"
+)
+
+// bangBangStrategy runs the unit at full blast until the room is comfortable, then turns it off.
+type bangBangStrategy struct{}
+
+func (s bangBangStrategy) StartHeat(ctx context.Context, hvac *models.Hvac, pump *models.Pump) {
+	if hvac.Mode.UnchangedFor() < hvac.AutoPilot.Tuning.AntiFlap.Minutes() {
+		L.Error("Hvac mode changed recently, preventing flapping.", "hvac", hvac.Name)
+		return
+	}
+	current, err := getCurrentTemp(hvac)
+	if err != nil {
+		L.Error(err.Error(), "hvac", hvac.Name)
+		return
+	}
+	if !hvac.AutoPilot.MinTemp.IsReady() {
+		L.Error("autopilot min temperature isn't initialized yet.", "hvac", hvac.Name)
+		return
+	}
+	if current <= hvac.DesiredMin() {
+		if !decideMode(ctx, hvac, pump, "HEAT", "Too cold, heating at full blast.") {
+			return
+		}
+		hvac.DecisionScore = 0
+		hvac.SetFan(ctx, "HIGH")
+		hvac.Temperature.Set(ctx, hvac.DesiredMin()+2)
+	}
+}
+
+func (s bangBangStrategy) TuneHeat(ctx context.Context, hvac *models.Hvac, pump *models.Pump) {
+	current, err := getCurrentTemp(hvac)
+	if err != nil {
+		L.Error(err.Error(), "hvac", hvac.Name)
+		return
+	}
+	if current >= hvac.DesiredMin()+1 {
+		decideStop(ctx, hvac, pump, "Warm enough, shutting down")
+	}
+}
+
+func (s bangBangStrategy) StartCold(ctx context.Context, hvac *models.Hvac, pump *models.Pump) {
+	if hvac.Mode.UnchangedFor() < hvac.AutoPilot.Tuning.AntiFlap.Minutes() {
+		L.Error("Hvac mode changed recently, preventing flapping.", "hvac", hvac.Name)
+		return
+	}
+	current, err := getCurrentTemp(hvac)
+	if err != nil {
+		L.Error(err.Error(), "hvac", hvac.Name)
+		return
+	}
+	if !hvac.AutoPilot.MaxTemp.IsReady() {
+		L.Error("autopilot max temperature isn't initialized yet.", "hvac", hvac.Name)
+		return
+	}
+	if current >= hvac.DesiredMax() {
+		if !decideMode(ctx, hvac, pump, "COOL", "Too hot, cooling at full blast.") {
+			return
+		}
+		hvac.DecisionScore = 0
+		hvac.SetFan(ctx, "HIGH")
+		hvac.Temperature.Set(ctx, hvac.DesiredMax()-2)
+	}
+}
+
+func (s bangBangStrategy) TuneCold(ctx context.Context, hvac *models.Hvac, pump *models.Pump) {
+	current, err := getCurrentTemp(hvac)
+	if err != nil {
+		L.Error(err.Error(), "hvac", hvac.Name)
+		return
+	}
+	if current <= hvac.DesiredMax()-1 {
+		decideStop(ctx, hvac, pump, "Cool enough, shutting down")
+	}
+}
+
+func (s bangBangStrategy) Stop(ctx context.Context, hvac *models.Hvac, pump *models.Pump) {
+	stop(ctx, hvac, pump)
+}
@@ -0,0 +1,85 @@
+package logic
+
+import (
+	"context"
+	"time"
+
+	"github.com/golang-collections/collections/set"
+
+	"github.com/nanassito/air/pkg/models"
+)
+
+const (
+	boostHeatTarget = 30.0
+	boostColdTarget = 17.0
+)
+
+// boostMode picks the mode to boost in, keeping the current one if the unit is already running.
+func boostMode(hvac *models.Hvac) string {
+	if mode := hvac.Mode.Get(); isRunning(mode) {
+		return mode
+	}
+	current, err := hvac.AutoPilot.Sensors.Air.Get()
+	if err != nil {
+		return "HEAT"
+	}
+	if current < (hvac.DesiredMin()+hvac.DesiredMax())/2 {
+		return "HEAT"
+	}
+	return "COOL"
+}
+
+func startBoost(ctx context.Context, hvac *models.Hvac, pump *models.Pump, usableModes *set.Set) {
+	mode := boostMode(hvac)
+	if !usableModes.Has(mode) {
+		decide(hvac, "Can't boost, the pump is running in another mode", "mode", mode)
+		return
+	}
+	if hvac.Mode.Get() != mode && !setMode(ctx, hvac, pump, mode) {
+		decide(hvac, "Can't boost, the pump refused to start", "mode", mode)
+		return
+	}
+	hvac.Boost.Until = time.Now().Add(hvac.AutoPilot.Tuning.BoostDuration.Minutes())
+	decide(hvac, "Boosting", "mode", mode, "until", hvac.Boost.Until)
+	hvac.SetFan(ctx, "HIGH")
+	if mode == "HEAT" {
+		hvac.Temperature.Set(ctx, boostHeatTarget)
+	} else {
+		hvac.Temperature.Set(ctx, boostColdTarget)
+	}
+}
+
+func endBoost(ctx context.Context, hvac *models.Hvac) {
+	decide(hvac, "Boost is over, back to the autopilot")
+	hvac.Boost.Until = time.Time{}
+	hvac.DecisionScore = 0
+	hvac.SetFan(ctx, "AUTO")
+	switch hvac.Mode.Get() {
+	case "HEAT":
+		hvac.Temperature.Set(ctx, hvac.DesiredMin())
+	case "COOL":
+		hvac.Temperature.Set(ctx, hvac.DesiredMax())
+	}
+}
+
+// runBoost handles boost requests and expiry. It returns true when it took care of the unit for this run.
+func runBoost(ctx context.Context, hvac *models.Hvac, pump *models.Pump, usableModes *set.Set) bool {
+	if hvac.Boost.Requested {
+		hvac.Boost.Requested = false
+		startBoost(ctx, hvac, pump, usableModes)
+	}
+	if !hvac.Boost.IsActive() {
+		return false
+	}
+	if !isRunning(hvac.Mode.Get()) {
+		decide(hvac, "Unit was turned off, cancelling the boost")
+		hvac.Boost.Until = time.Time{}
+		hvac.DecisionScore = 0
+		return false
+	}
+	if time.Now().Before(hvac.Boost.Until) {
+		return true
+	}
+	endBoost(ctx, hvac)
+	return true
+}
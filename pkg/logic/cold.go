@@ -1,6 +1,7 @@
 package logic
 
 import (
+	"context"
 	"math"
 	"time"
 
@@ -8,8 +9,8 @@ import (
 	"github.com/nanassito/air/pkg/mqtt"
 )
 
-func StartCold(hvac *models.Hvac) {
-	if hvac.Mode.UnchangedFor() < 30*time.Minute {
+func StartCold(ctx context.Context, hvac *models.Hvac, pump *models.Pump) {
+	if hvac.Mode.UnchangedFor() < hvac.AutoPilot.Tuning.AntiFlap.Minutes() {
 		L.Error("Hvac mode changed recently, preventing flapping.")
 		return
 	}
@@ -24,43 +25,52 @@ func StartCold(hvac *models.Hvac) {
 		return
 	}
 
-	if current >= hvac.AutoPilot.MaxTemp.Get()-1 {
-		L.Info("Temperature rised enough that we should restart the cooling cycle.", "hvac", hvac.Name)
-		hvac.DecisionScore = 0
+	early := current < hvac.DesiredMax()-1 && startAhead(hvac, "COOL")
+	if current >= hvac.DesiredMax()-1 || early {
 		inUnit, err := hvac.AutoPilot.Sensors.Unit.Get()
 		if err != nil {
 			L.Info("unknown current temperature in the unit", "hvac", hvac.Name)
 			return
 		}
-		hvac.Mode.Set("COOL")
+		decision := "Temperature rised enough that we should restart the cooling cycle."
+		if early {
+			decision = "Room will be too hot before the unit catches up, starting ahead of time."
+		}
+		if !decideMode(ctx, hvac, pump, "COOL", decision) {
+			return
+		}
+		hvac.DecisionScore = 0
 
-		if inUnit > hvac.AutoPilot.MaxTemp.Get()+2 {
+		if inUnit > hvac.DesiredMax()+2 {
 			// If there is a large temperature difference between the in-unit sensor and the target temperature,
 			// we want to first mix the air.
-			hvac.Temperature.Set(30)
-			hvac.Fan.Set("HIGH")
-			go func() {
-				// After some time we can tweak the settings to maximize comfort.
-				time.Sleep(5 * time.Minute)
-				hvac.Fan.Set("AUTO")
+			hvac.Temperature.Set(ctx, 30)
+			hvac.SetFan(ctx, "HIGH")
+			// After some time we can tweak the settings to maximize comfort.
+			hvac.Actions.Schedule(ctx, "settle cooling", 5*time.Minute, func(ctx context.Context) {
+				if hvac.Mode.Get() != "COOL" || !hvac.AutoPilot.Enabled.Get() {
+					L.Info("Not settling, the unit is no longer cooling on autopilot", "hvac", hvac.Name)
+					return
+				}
+				hvac.SetFan(ctx, "AUTO")
 				inUnit, err := hvac.AutoPilot.Sensors.Unit.Get()
 				if err != nil {
 					L.Info("unknown current temperature in the unit", "hvac", hvac.Name)
 					return
 				}
-				hvac.Temperature.Set(math.Max(inUnit, hvac.AutoPilot.MaxTemp.Get()+2))
-			}()
+				hvac.Temperature.Set(ctx, math.Max(inUnit, hvac.DesiredMax()+2))
+			})
 		} else {
 			// The HVAC unit has a flawed perception of the temperature in the room and so it can't set it's own
 			// temperature correctly. We make up for it by targetting teh higher of the in-unit temperature and
 			// the desired temperature (plus a buffer) to minimize the risk of over-cooling.
-			hvac.Temperature.Set(math.Max(inUnit, hvac.AutoPilot.MaxTemp.Get()+2))
-			hvac.Fan.Set("AUTO")
+			hvac.Temperature.Set(ctx, math.Max(inUnit, hvac.DesiredMax()+2))
+			hvac.SetFan(ctx, "AUTO")
 		}
 	}
 }
 
-func TuneCold(hvac *models.Hvac, pump *models.Pump) {
+func TuneCold(ctx context.Context, hvac *models.Hvac, pump *models.Pump) {
 	current, err := getCurrentTemp(hvac)
 	if err != nil {
 		L.Error(err.Error(), "hvac", hvac.Name)
@@ -71,20 +81,16 @@ func TuneCold(hvac *models.Hvac, pump *models.Pump) {
 		return
 	}
 
-	maxDesired := hvac.AutoPilot.MaxTemp.Get()
-	L.Info("Tuning cold", "current", current, "maxDesired", hvac.AutoPilot.MaxTemp.Get(), "hvac", hvac.Name)
+	maxDesired := hvac.DesiredMax()
+	L.Info("Tuning cold", "current", current, "maxDesired", maxDesired, "hvac", hvac.Name)
 
-	if current < maxDesired-3 {
-		L.Info("It's way too cold, shutting down", "hvac", hvac.Name)
-		hvac.Mode.Set("OFF")
-		hvac.DecisionScore = 0
+	if current < maxDesired-hvac.AutoPilot.Tuning.ShutdownBand.Get() {
+		decideStop(ctx, hvac, pump, "It's way too cold, shutting down")
 		return
 	}
 	unitTempRange := hvac.AutoPilot.Sensors.Unit.GetRange()
 	if hvac.Mode.UnchangedFor() > 3*time.Hour && current < maxDesired && unitTempRange < 1 && hvac.AutoPilot.Sensors.Air.GetTrend() != mqtt.TrendWarmingUp {
-		L.Info("Unit hasn't been effective for a while, shutting down", "hvac", hvac.Name, "unitTempRange", unitTempRange)
-		hvac.Mode.Set("OFF")
-		hvac.DecisionScore = 0
+		decideStop(ctx, hvac, pump, "Unit hasn't been effective for a while, shutting down", "unitTempRange", unitTempRange)
 		return
 	}
 
@@ -109,23 +115,23 @@ func TuneCold(hvac *models.Hvac, pump *models.Pump) {
 
 	if current < maxDesired-1+minOffset {
 		hvac.DecisionScore += 1
-		L.Info("Need less cold", "hvac", hvac.Name)
+		decide(hvac, "Need less cold")
 	} else if current >= maxDesired+minOffset {
 		hvac.DecisionScore -= 1
-		L.Info("Need more cold", "hvac", hvac.Name)
+		decide(hvac, "Need more cold")
 	} else {
-		L.Info("Not doing anything", "hvac", hvac.Name)
+		decide(hvac, "Not doing anything")
 	}
 
-	switch hvac.DecisionScore {
-	case -60:
-		L.Info("Reducing temperature", "hvac", hvac.Name)
+	switch limit := hvac.AutoPilot.Tuning.ColdScoreLimit.Get(); {
+	case hvac.DecisionScore <= -limit:
+		decide(hvac, "Reducing temperature")
 		hvac.DecisionScore = 0
-		hvac.Temperature.Set(hvac.Temperature.Get() - 0.5)
-	case 60:
-		L.Info("Increasing temperature", "hvac", hvac.Name)
+		hvac.Temperature.Set(ctx, hvac.Temperature.Get()-0.5)
+	case hvac.DecisionScore >= limit:
+		decide(hvac, "Increasing temperature")
 		hvac.DecisionScore = 0
-		hvac.Temperature.Set(hvac.Temperature.Get() + 0.5)
+		hvac.Temperature.Set(ctx, hvac.Temperature.Get()+0.5)
 	}
 	L.Info("Completing TuneCold", "hvac", hvac.Name, "decisionScore", hvac.DecisionScore)
 }
@@ -0,0 +1,80 @@
+package logic
+
+import (
+	"context"
+	"time"
+
+	"github.com/nanassito/air/pkg/models"
+)
+
+func isRunning(mode string) bool {
+	return mode == "HEAT" || mode == "COOL"
+}
+
+func runningUnits(pump *models.Pump) int {
+	count := 0
+	for _, hvac := range pump.Units {
+		if isRunning(hvac.Mode.Get()) {
+			count++
+		}
+	}
+	return count
+}
+
+// recordCompressor updates the compressor state, including changes made outside of the autopilot.
+func recordCompressor(pump *models.Pump) {
+	running := runningUnits(pump) > 0
+	if running == pump.Compressor.Running {
+		return
+	}
+	now := time.Now()
+	pump.Compressor.Running = running
+	pump.Compressor.Since = now
+	if running {
+		starts := []time.Time{now}
+		for _, start := range pump.Compressor.Starts {
+			if now.Sub(start) <= time.Hour {
+				starts = append(starts, start)
+			}
+		}
+		pump.Compressor.Starts = starts
+	}
+}
+
+// setMode changes the mode of the hvac unless it would cycle the compressor too quickly.
+func setMode(ctx context.Context, hvac *models.Hvac, pump *models.Pump, mode string) bool {
+	recordCompressor(pump)
+	protection := pump.Protection
+	wasRunning, willRun := isRunning(hvac.Mode.Get()), isRunning(mode)
+	switch {
+	case !wasRunning && willRun && !pump.Compressor.Running:
+		if off := time.Since(pump.Compressor.Since); off < protection.MinOff {
+			L.Warn("Compressor was stopped too recently to start again", "hvac", hvac.Name, "pump", pump.Name, "off", off)
+			return false
+		}
+		if max := protection.MaxStartsPerHour; max > 0 && pump.Compressor.StartsWithin(time.Hour) >= max {
+			L.Warn("Compressor started too many times in the last hour", "hvac", hvac.Name, "pump", pump.Name, "max", max)
+			return false
+		}
+	case wasRunning && !willRun && runningUnits(pump) == 1:
+		if on := time.Since(pump.Compressor.Since); on < protection.MinOn {
+			L.Warn("Compressor was started too recently to stop", "hvac", hvac.Name, "pump", pump.Name, "on", on)
+			return false
+		}
+	}
+	if !wasRunning && willRun {
+		if elapsed := time.Since(pump.LastUnitStart); elapsed < pump.StartInterval {
+			L.Info("Waiting before starting another unit on the pump", "hvac", hvac.Name, "pump", pump.Name, "elapsed", elapsed)
+			return false
+		}
+		pump.LastUnitStart = time.Now()
+		// The pump now runs in this mode, whether the unit acknowledged it yet or not.
+		if pump.Arbitration.Mode != mode {
+			pump.Arbitration.Mode, pump.Arbitration.Since = mode, time.Now()
+		}
+	}
+	hvac.Actions.CancelAll()
+	hvac.Mode.Set(ctx, mode)
+	recordCompressor(pump)
+	return true
+}
@@ -1,14 +1,15 @@
 package logic
 
 import (
-	"time"
+	"context"
 
 	"github.com/nanassito/air/pkg/models"
 	"github.com/nanassito/air/pkg/mqtt"
 )
 
-func StartHeat(hvac *models.Hvac) {
-	if hvac.Mode.UnchangedFor() < 30*time.Minute {
+func StartHeat(ctx context.Context, hvac *models.Hvac, pump *models.Pump) {
+	tuning := hvac.AutoPilot.Tuning
+	if hvac.Mode.UnchangedFor() < tuning.AntiFlap.Minutes() {
 		L.Error("Hvac mode changed recently, preventing flapping.")
 		return
 	}
@@ -23,27 +24,33 @@ func StartHeat(hvac *models.Hvac) {
 		return
 	}
 
-	if current <= hvac.AutoPilot.MinTemp.Get()+1 {
-		if hvac.Mode.UnchangedFor() < 30*time.Minute {
-			L.Info("Hvac was shutdown not long enough ago.", "hvac", hvac.Name)
+	early := current > hvac.DesiredMin()+1 && startAhead(hvac, "HEAT")
+	if current <= hvac.DesiredMin()+1 || early {
+		if hvac.Mode.UnchangedFor() < tuning.AntiFlap.Minutes() {
+			decide(hvac, "Hvac was shutdown not long enough ago.")
+			return
+		}
+		decision := "Temperature lowered enough that we should restart the heating cycle."
+		if early {
+			decision = "Room will be too cold before the unit catches up, starting ahead of time."
+		}
+		if !decideMode(ctx, hvac, pump, "HEAT", decision) {
 			return
 		}
-		L.Info("Temperature lowered enough that we should restart the heating cycle.", "hvac", hvac.Name)
 		hvac.DecisionScore = 0
-		hvac.Mode.Set("HEAT")
-		hvac.Fan.Set("AUTO")
-		if current <= hvac.AutoPilot.MinTemp.Get()+1 {
+		hvac.SetFan(ctx, "AUTO")
+		if current <= hvac.DesiredMin()+1 {
 			// We still have some marging so let's restart with a low target temperature
-			hvac.Temperature.Set(17)
+			hvac.Temperature.Set(ctx, tuning.HeatFloor.Get())
 		} else {
-			// We've lost a lot of heat already so let's restart hard.
-			hvac.Temperature.Set(hvac.AutoPilot.MinTemp.Get())
+			// Starting ahead of time, so hold the bottom of the range.
+			hvac.Temperature.Set(ctx, hvac.DesiredMin())
 		}
 		return
 	}
 }
 
-func TuneHeat(hvac *models.Hvac, pump *models.Pump) {
+func TuneHeat(ctx context.Context, hvac *models.Hvac, pump *models.Pump) {
 	current, err := getCurrentTemp(hvac)
 	if err != nil {
 		L.Error(err.Error(), "hvac", hvac.Name)
@@ -54,13 +61,12 @@ func TuneHeat(hvac *models.Hvac, pump *models.Pump) {
 		return
 	}
 
-	minDesired := hvac.AutoPilot.MinTemp.Get()
-	L.Info("Tuning heat", "current", current, "minDesired", hvac.AutoPilot.MaxTemp.Get(), "hvac", hvac.Name)
+	tuning := hvac.AutoPilot.Tuning
+	minDesired := hvac.DesiredMin()
+	L.Info("Tuning heat", "current", current, "minDesired", minDesired, "hvac", hvac.Name)
 
-	if current > minDesired+3 {
-		L.Info("It's way too hot, shutting down", "hvac", hvac.Name)
-		hvac.Mode.Set("OFF")
-		hvac.DecisionScore = 0
+	if current > minDesired+tuning.ShutdownBand.Get() {
+		decideStop(ctx, hvac, pump, "It's way too hot, shutting down")
 		return
 	}
 
@@ -85,39 +91,37 @@ func TuneHeat(hvac *models.Hvac, pump *models.Pump) {
 
 	if current <= minDesired+minOffset {
 		hvac.DecisionScore += 1
-		L.Info("Need more heat", "hvac", hvac.Name)
+		decide(hvac, "Need more heat")
 	} else if current > minDesired+1+minOffset {
 		hvac.DecisionScore -= 1
-		L.Info("Need less heat", "hvac", hvac.Name)
+		decide(hvac, "Need less heat")
 	} else {
-		L.Info("Not doing anything", "hvac", hvac.Name)
+		decide(hvac, "Not doing anything")
 	}
 
-	switch hvac.DecisionScore {
-	case -100:
-		if hvac.Temperature.Get() == 17.0 {
-			L.Info("Heating is ineffective, shutting down", "hvac", hvac.Name)
-			hvac.Mode.Set("OFF")
-			hvac.DecisionScore = 0
+	switch limit := tuning.HeatScoreLimit.Get(); {
+	case hvac.DecisionScore <= -limit:
+		if hvac.Temperature.Get() <= tuning.HeatFloor.Get() {
+			decideStop(ctx, hvac, pump, "Heating is ineffective, shutting down")
 			return
 		}
 		hvac.DecisionScore = 0
-		L.Info("Reducing fan temperature", "hvac", hvac.Name)
-		hvac.Temperature.Set(hvac.Temperature.Get() - 0.5)
-	case 100:
+		decide(hvac, "Reducing fan temperature")
+		hvac.Temperature.Set(ctx, hvac.Temperature.Get()-0.5)
+	case hvac.DecisionScore >= limit:
 		hvac.DecisionScore = 0
-		L.Info("Increasing temperature", "hvac", hvac.Name)
-		hvac.Temperature.Set(hvac.Temperature.Get() + 0.5)
+		decide(hvac, "Increasing temperature")
+		hvac.Temperature.Set(ctx, hvac.Temperature.Get()+0.5)
 	}
 
-	if commandDelta := hvac.Temperature.Get() - hvac.AutoPilot.MinTemp.Get(); commandDelta >= 1.5 {
+	if commandDelta := hvac.Temperature.Get() - hvac.DesiredMin(); commandDelta >= 1.5 {
 		if commandDelta >= 3 {
-			hvac.Fan.Set("HIGH")
+			hvac.SetFan(ctx, "HIGH")
 		} else {
-			hvac.Fan.Set("MEDIUM")
+			hvac.SetFan(ctx, "MEDIUM")
 		}
 	} else {
-		hvac.Fan.Set("LOW")
+		hvac.SetFan(ctx, "LOW")
 	}
 	L.Info("Completing TuneHeat", "hvac", hvac.Name, "decisionScore", hvac.DecisionScore)
 }
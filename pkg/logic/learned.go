@@ -0,0 +1,61 @@
+package logic
+
+import (
+	"context"
+
+	"github.com/nanassito/air/pkg/models"
+)
+
+// learnedStrategy uses the thermal model of the room to start just early enough for the room to never leave the
+// comfort range, then tunes the unit like the score strategy.
+type learnedStrategy struct {
+	scoreStrategy
+}
+
+func (s learnedStrategy) StartHeat(ctx context.Context, hvac *models.Hvac, pump *models.Pump) {
+	if hvac.Mode.UnchangedFor() < hvac.AutoPilot.Tuning.AntiFlap.Minutes() {
+		L.Error("Hvac mode changed recently, preventing flapping.", "hvac", hvac.Name)
+		return
+	}
+	if _, err := getCurrentTemp(hvac); err != nil {
+		L.Error(err.Error(), "hvac", hvac.Name)
+		return
+	}
+	if !hvac.AutoPilot.MinTemp.IsReady() {
+		L.Error("autopilot min temperature isn't initialized yet.", "hvac", hvac.Name)
+		return
+	}
+	lead := hvac.StartLead("HEAT", "AUTO")
+	if forecast := hvac.Forecast(lead); forecast <= hvac.DesiredMin() {
+		if !decideMode(ctx, hvac, pump, "HEAT", "Room will be too cold by the time it warms up, starting to heat.", "forecast", forecast, "lead", lead) {
+			return
+		}
+		hvac.DecisionScore = 0
+		hvac.SetFan(ctx, "AUTO")
+		hvac.Temperature.Set(ctx, hvac.DesiredMin())
+	}
+}
+
+func (s learnedStrategy) StartCold(ctx context.Context, hvac *models.Hvac, pump *models.Pump) {
+	if hvac.Mode.UnchangedFor() < hvac.AutoPilot.Tuning.AntiFlap.Minutes() {
+		L.Error("Hvac mode changed recently, preventing flapping.", "hvac", hvac.Name)
+		return
+	}
+	if _, err := getCurrentTemp(hvac); err != nil {
+		L.Error(err.Error(), "hvac", hvac.Name)
+		return
+	}
+	if !hvac.AutoPilot.MaxTemp.IsReady() {
+		L.Error("autopilot max temperature isn't initialized yet.", "hvac", hvac.Name)
+		return
+	}
+	lead := hvac.StartLead("COOL", "AUTO")
+	if forecast := hvac.Forecast(lead); forecast >= hvac.DesiredMax() {
+		if !decideMode(ctx, hvac, pump, "COOL", "Room will be too hot by the time it cools down, starting to cool.", "forecast", forecast, "lead", lead) {
+			return
+		}
+		hvac.DecisionScore = 0
+		hvac.SetFan(ctx, "AUTO")
+		hvac.Temperature.Set(ctx, hvac.DesiredMax())
+	}
+}
@@ -1,13 +1,20 @@
 package logic_test
 
 import (
+	"context"
 	"testing"
+	"time"
 
+	paho "github.com/eclipse/paho.mqtt.golang"
 	"github.com/matryer/is"
 
 	"github.com/nanassito/air/pkg/logic"
 	"github.com/nanassito/air/pkg/mocks"
 	"github.com/nanassito/air/pkg/models"
+	"github.com/nanassito/air/pkg/mqtt"
+	"github.com/nanassito/air/pkg/scheduler"
+	"github.com/nanassito/air/pkg/tsdb"
+	"github.com/nanassito/air/pkg/utils"
 )
 
 func TestHeatTurnsOn(t *testing.T) {
@@ -33,7 +40,7 @@ func TestHeatTurnsOn(t *testing.T) {
 	mocks.DesiredMinTemp(mqttClient, roomName, 20)
 	roomTemp.Set(18)
 
-	logic.TunePump(pumps[0])
+	logic.TunePump(context.Background(), pumps[0])
 
 	is.Equal("HEAT", pumps[0].Units[0].Mode.Get())
 }
@@ -62,7 +69,7 @@ func TestColdTurnsOn(t *testing.T) {
 	mocks.DesiredMaxTemp(mqttClient, roomName, 23)
 	roomTemp.Set(28)
 
-	logic.TunePump(pumps[0])
+	logic.TunePump(context.Background(), pumps[0])
 
 	is.Equal("COOL", pumps[0].Units[0].Mode.Get())
 }
@@ -92,15 +99,15 @@ func TestDontFlipMode(t *testing.T) {
 	mocks.DesiredMinTemp(mqttClient, roomName, 20)
 	roomTemp.Set(28)
 
-	logic.TunePump(pumps[0])
+	logic.TunePump(context.Background(), pumps[0])
 
 	is.Equal("COOL", pumps[0].Units[0].Mode.Get())
 
 	roomTemp.Set(18) // The AC cooled off the room too much
 
-	logic.TunePump(pumps[0])
-	logic.TunePump(pumps[0]) // Run multiple time to ensure we shut off and don't flap
-	logic.TunePump(pumps[0])
+	logic.TunePump(context.Background(), pumps[0])
+	logic.TunePump(context.Background(), pumps[0]) // Run multiple time to ensure we shut off and don't flap
+	logic.TunePump(context.Background(), pumps[0])
 
 	is.Equal("OFF", pumps[0].Units[0].Mode.Get())
 }
@@ -132,7 +139,7 @@ func TestStops(t *testing.T) {
 		hvac.SetMode("COOL")
 		mocks.DesiredMaxTemp(mqttClient, roomName, 30)
 
-		logic.TunePump(pumps[0])
+		logic.TunePump(context.Background(), pumps[0])
 
 		is.Equal("OFF", pumps[0].Units[0].Mode.Get())
 	})
@@ -141,8 +148,642 @@ func TestStops(t *testing.T) {
 		hvac.SetMode("HEAT")
 		mocks.DesiredMinTemp(mqttClient, roomName, 20)
 
-		logic.TunePump(pumps[0])
+		logic.TunePump(context.Background(), pumps[0])
 
 		is.Equal("OFF", pumps[0].Units[0].Mode.Get())
 	})
 }
+
+func TestBangBangStrategy(t *testing.T) {
+	is := is.New(t)
+	mqttClient := mocks.NewMockMqtt()
+
+	roomName := "test_room"
+	roomTemp := mocks.NewMockTemperatureSensor(mqttClient, "sensor1")
+	pumps := []*models.Pump{
+		{
+			Units: []*models.Hvac{
+				models.NewHvacWithDefaultTopics(
+					mqttClient,
+					roomName,
+					roomTemp.Topic(),
+				),
+			},
+		},
+	}
+	mocks.NewMockHvac(mqttClient, roomName)
+
+	mocks.Autopilot(mqttClient, roomName, true)
+	mocks.Strategy(mqttClient, roomName, "bangbang")
+	mocks.DesiredMinTemp(mqttClient, roomName, 20)
+	roomTemp.Set(19.5)
+
+	logic.TunePump(context.Background(), pumps[0])
+
+	is.Equal("HEAT", pumps[0].Units[0].Mode.Get())
+	is.Equal("HIGH", pumps[0].Units[0].Fan.Get())
+	is.Equal(22.0, pumps[0].Units[0].Temperature.Get())
+
+	roomTemp.Set(21)
+	logic.TunePump(context.Background(), pumps[0])
+
+	is.Equal("OFF", pumps[0].Units[0].Mode.Get())
+
+	mocks.Strategy(mqttClient, roomName, "nonsense")
+	is.Equal("bangbang", pumps[0].Units[0].AutoPilot.Strategy.Get())
+}
+
+func TestPIDStrategy(t *testing.T) {
+	is := is.New(t)
+	mqttClient := mocks.NewMockMqtt()
+
+	roomTemp := mocks.NewMockTemperatureSensor(mqttClient, "room_sensor")
+	pump := &models.Pump{
+		Units: []*models.Hvac{
+			models.NewHvacWithDefaultTopics(mqttClient, "room", roomTemp.Topic()),
+		},
+	}
+	hvac := pump.Units[0]
+	mocks.NewMockHvac(mqttClient, "room")
+	mocks.Strategy(mqttClient, "room", "pid")
+	mocks.DesiredMinTemp(mqttClient, "room", 20)
+	roomTemp.Set(19)
+
+	logic.TunePump(context.Background(), pump)
+	is.Equal("HEAT", hvac.Mode.Get())
+	// 1.5°C short of the setpoint, so it aims 2.25°C above it.
+	is.Equal(23.0, hvac.Temperature.Get())
+
+	roomTemp.Set(20.5)
+	logic.TunePump(context.Background(), pump)
+	is.Equal("HEAT", hvac.Mode.Get())
+	is.True(hvac.Temperature.Get() < 23) // Warming up fast, so it eases off.
+}
+
+func TestArbitration(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		hold     time.Duration
+		priority []string
+		heatMode string
+		coolMode string
+	}{
+		{name: "switch to the largest demand", hold: 0, heatMode: "OFF", coolMode: "COOL"},
+		{name: "hold the current mode", hold: time.Hour, heatMode: "HEAT", coolMode: "OFF"},
+		{name: "priority room wins", hold: 0, priority: []string{"heat_room"}, heatMode: "HEAT", coolMode: "OFF"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			is := is.New(t)
+			mqttClient := mocks.NewMockMqtt()
+
+			heatTemp := mocks.NewMockTemperatureSensor(mqttClient, "heat_sensor")
+			coolTemp := mocks.NewMockTemperatureSensor(mqttClient, "cool_sensor")
+			pump := &models.Pump{
+				Name:        "pump",
+				MinModeHold: tc.hold,
+				Priority:    tc.priority,
+				Units: []*models.Hvac{
+					models.NewHvacWithDefaultTopics(mqttClient, "heat_room", heatTemp.Topic()),
+					models.NewHvacWithDefaultTopics(mqttClient, "cool_room", coolTemp.Topic()),
+				},
+			}
+			mocks.NewMockHvac(mqttClient, "heat_room")
+			mocks.NewMockHvac(mqttClient, "cool_room").ReportUnitTemperature(26)
+			mocks.DesiredMinTemp(mqttClient, "heat_room", 20)
+
+			heatTemp.Set(18)
+			logic.TunePump(context.Background(), pump)
+			is.Equal("HEAT", pump.Units[0].Mode.Get())
+
+			heatTemp.Set(20.5)
+			mocks.DesiredMaxTemp(mqttClient, "cool_room", 23)
+			coolTemp.Set(28)
+			logic.TunePump(context.Background(), pump)
+
+			is.Equal(tc.heatMode, pump.Units[0].Mode.Get())
+			is.Equal(tc.coolMode, pump.Units[1].Mode.Get())
+			is.True(pump.Arbitration.Reason != "")
+		})
+	}
+}
+
+func TestIdlePumpStartsOneMode(t *testing.T) {
+	is := is.New(t)
+	mqttClient := mocks.NewMockMqtt()
+
+	heatTemp := mocks.NewMockTemperatureSensor(mqttClient, "heat_sensor")
+	coolTemp := mocks.NewMockTemperatureSensor(mqttClient, "cool_sensor")
+	pump := &models.Pump{
+		Name: "pump",
+		Units: []*models.Hvac{
+			models.NewHvacWithDefaultTopics(mqttClient, "heat_room", heatTemp.Topic()),
+			models.NewHvacWithDefaultTopics(mqttClient, "cool_room", coolTemp.Topic()),
+		},
+	}
+	mocks.NewMockHvac(mqttClient, "heat_room")
+	mocks.NewMockHvac(mqttClient, "cool_room")
+	mocks.DesiredMinTemp(mqttClient, "heat_room", 19)
+	mocks.DesiredMinTemp(mqttClient, "cool_room", 19)
+	mocks.DesiredMaxTemp(mqttClient, "cool_room", 26)
+	// Neither room has any demand, but a boost wants heat in one and cold in the other.
+	heatTemp.Set(21)
+	coolTemp.Set(24)
+	for _, hvac := range pump.Units {
+		hvac.Boost.Requested = true
+	}
+
+	logic.TunePump(context.Background(), pump)
+	running := []string{}
+	for _, hvac := range pump.Units {
+		if mode := hvac.Mode.Get(); mode != "OFF" {
+			running = append(running, mode)
+		}
+	}
+	is.Equal(1, len(running))
+	is.Equal(running[0], pump.Arbitration.Mode)
+}
+
+func TestCompressorProtection(t *testing.T) {
+	is := is.New(t)
+	mqttClient := mocks.NewMockMqtt()
+
+	firstTemp := mocks.NewMockTemperatureSensor(mqttClient, "first_sensor")
+	secondTemp := mocks.NewMockTemperatureSensor(mqttClient, "second_sensor")
+	pump := &models.Pump{
+		Name: "pump",
+		Units: []*models.Hvac{
+			models.NewHvacWithDefaultTopics(mqttClient, "first", firstTemp.Topic()),
+			models.NewHvacWithDefaultTopics(mqttClient, "second", secondTemp.Topic()),
+		},
+	}
+	mocks.NewMockHvac(mqttClient, "first")
+	mocks.NewMockHvac(mqttClient, "second")
+	mocks.DesiredMinTemp(mqttClient, "first", 20)
+	mocks.DesiredMinTemp(mqttClient, "second", 20)
+
+	t.Run("min on", func(t *testing.T) {
+		pump.Protection = models.CompressorProtection{MinOn: time.Hour}
+		firstTemp.Set(18)
+		logic.TunePump(context.Background(), pump)
+		is.Equal("HEAT", pump.Units[0].Mode.Get())
+
+		firstTemp.Set(25) // Way too hot but the compressor just started.
+		logic.TunePump(context.Background(), pump)
+		is.Equal("HEAT", pump.Units[0].Mode.Get())
+	})
+
+	t.Run("min off", func(t *testing.T) {
+		pump.Protection = models.CompressorProtection{MinOff: time.Hour}
+		logic.TunePump(context.Background(), pump)
+		is.Equal("OFF", pump.Units[0].Mode.Get())
+
+		secondTemp.Set(18) // The compressor just stopped so it can't start again.
+		logic.TunePump(context.Background(), pump)
+		is.Equal("OFF", pump.Units[1].Mode.Get())
+		is.Equal("", pump.Units[1].LastDecision) // Nothing was carried out.
+		is.True(!pump.Compressor.Running)
+	})
+}
+
+func TestStaggeredStart(t *testing.T) {
+	is := is.New(t)
+	mqttClient := mocks.NewMockMqtt()
+
+	chillyTemp := mocks.NewMockTemperatureSensor(mqttClient, "chilly_sensor")
+	freezingTemp := mocks.NewMockTemperatureSensor(mqttClient, "freezing_sensor")
+	pump := &models.Pump{
+		Name:          "pump",
+		StartInterval: time.Hour,
+		Units: []*models.Hvac{
+			models.NewHvacWithDefaultTopics(mqttClient, "chilly", chillyTemp.Topic()),
+			models.NewHvacWithDefaultTopics(mqttClient, "freezing", freezingTemp.Topic()),
+		},
+	}
+	mocks.NewMockHvac(mqttClient, "chilly")
+	mocks.NewMockHvac(mqttClient, "freezing")
+	mocks.DesiredMinTemp(mqttClient, "chilly", 20)
+	mocks.DesiredMinTemp(mqttClient, "freezing", 20)
+	chillyTemp.Set(19)
+	freezingTemp.Set(15)
+
+	logic.TunePump(context.Background(), pump)
+
+	is.Equal("OFF", pump.Units[0].Mode.Get())
+	is.Equal("HEAT", pump.Units[1].Mode.Get()) // The coldest room starts first.
+}
+
+func TestColdFollowUp(t *testing.T) {
+	is := is.New(t)
+	mqttClient := mocks.NewMockMqtt()
+	clock := mocks.NewFakeClock()
+
+	roomName := "test_room"
+	roomTemp := mocks.NewMockTemperatureSensor(mqttClient, "sensor1")
+	pump := &models.Pump{
+		Units: []*models.Hvac{
+			models.NewHvacWithDefaultTopics(mqttClient, roomName, roomTemp.Topic()),
+		},
+	}
+	hvac := pump.Units[0]
+	hvac.Actions = scheduler.New(clock, utils.MainLoop.Do)
+	mockHvac := mocks.NewMockHvac(mqttClient, roomName)
+	mockHvac.ReportUnitTemperature(30)
+	mocks.DesiredMaxTemp(mqttClient, roomName, 23)
+	roomTemp.Set(28)
+
+	logic.TunePump(context.Background(), pump)
+	is.Equal("COOL", hvac.Mode.Get())
+	is.Equal("HIGH", hvac.Fan.Get())
+	is.Equal(1, len(hvac.Actions.Pending()))
+
+	t.Run("settles", func(t *testing.T) {
+		clock.Advance(5 * time.Minute)
+		is.Equal("HIGH", hvac.Fan.Get()) // Left to the main loop.
+		utils.MainLoop.RunPending()
+		is.Equal("AUTO", hvac.Fan.Get())
+		is.Equal(30.0, hvac.Temperature.Get())
+	})
+
+	t.Run("cancelled when turned off", func(t *testing.T) {
+		hvac.Actions.Schedule(context.Background(), "settle cooling", 5*time.Minute, func(ctx context.Context) { hvac.Fan.Set(ctx, "HIGH") })
+		mockHvac.SetMode("OFF")
+		logic.TunePump(context.Background(), pump)
+		is.Equal(0, len(hvac.Actions.Pending()))
+		clock.Advance(5 * time.Minute)
+		utils.MainLoop.RunPending()
+		is.Equal("AUTO", hvac.Fan.Get())
+	})
+}
+
+func TestColdFollowUpAfterStop(t *testing.T) {
+	is := is.New(t)
+	mqttClient := mocks.NewMockMqtt()
+	clock := mocks.NewFakeClock()
+
+	roomTemp := mocks.NewMockTemperatureSensor(mqttClient, "sensor1")
+	pump := &models.Pump{
+		Units: []*models.Hvac{
+			models.NewHvacWithDefaultTopics(mqttClient, "room", roomTemp.Topic()),
+		},
+	}
+	hvac := pump.Units[0]
+	hvac.Actions = scheduler.New(clock, utils.MainLoop.Do)
+	mockHvac := mocks.NewMockHvac(mqttClient, "room")
+	mockHvac.ReportUnitTemperature(30)
+	mocks.DesiredMaxTemp(mqttClient, "room", 23)
+	roomTemp.Set(28)
+
+	logic.TunePump(context.Background(), pump)
+	is.Equal("COOL", hvac.Mode.Get())
+
+	// The action is due, but the unit gets turned off by hand before the main loop runs it.
+	clock.Advance(5 * time.Minute)
+	mockHvac.SetMode("OFF")
+	utils.MainLoop.RunPending()
+	is.Equal("HIGH", hvac.Fan.Get())
+	is.Equal(30.0, hvac.Temperature.Get())
+}
+
+func TestTuning(t *testing.T) {
+	is := is.New(t)
+	mqttClient := mocks.NewMockMqtt()
+
+	roomName := "test_room"
+	roomTemp := mocks.NewMockTemperatureSensor(mqttClient, "sensor1")
+	pump := &models.Pump{
+		Units: []*models.Hvac{
+			models.NewHvacWithDefaultTopics(mqttClient, roomName, roomTemp.Topic()),
+		},
+	}
+	mocks.NewMockHvac(mqttClient, roomName)
+	mocks.DesiredMinTemp(mqttClient, roomName, 20)
+	mocks.Tuning(mqttClient, roomName, "heat_floor", 18)
+	mocks.Tuning(mqttClient, roomName, "heat_floor", 99) // Out of range, ignored.
+	roomTemp.Set(20.5)
+
+	logic.TunePump(context.Background(), pump)
+
+	is.Equal("HEAT", pump.Units[0].Mode.Get())
+	is.Equal(18.0, pump.Units[0].Temperature.Get())
+}
+
+func TestBoost(t *testing.T) {
+	is := is.New(t)
+	mqttClient := mocks.NewMockMqtt()
+
+	roomTemp := mocks.NewMockTemperatureSensor(mqttClient, "room_sensor")
+	otherTemp := mocks.NewMockTemperatureSensor(mqttClient, "other_sensor")
+	pump := &models.Pump{
+		Units: []*models.Hvac{
+			models.NewHvacWithDefaultTopics(mqttClient, "room", roomTemp.Topic()),
+			models.NewHvacWithDefaultTopics(mqttClient, "other", otherTemp.Topic()),
+		},
+	}
+	hvac := pump.Units[0]
+	mocks.NewMockHvac(mqttClient, "room")
+	other := mocks.NewMockHvac(mqttClient, "other")
+	mocks.DesiredMinTemp(mqttClient, "room", 20)
+	roomTemp.Set(22)
+
+	t.Run("incompatible with the pump", func(t *testing.T) {
+		other.SetMode("COOL")
+		mocks.Boost(mqttClient, "room")
+		logic.TunePump(context.Background(), pump)
+		is.Equal("OFF", hvac.Mode.Get())
+		is.True(!hvac.Boost.IsActive())
+		other.SetMode("OFF")
+	})
+
+	t.Run("starts", func(t *testing.T) {
+		mocks.Boost(mqttClient, "room")
+		is.True(!hvac.Boost.Requested) // Left to the main loop.
+		logic.TunePump(context.Background(), pump)
+		is.Equal("HEAT", hvac.Mode.Get())
+		is.Equal("HIGH", hvac.Fan.Get())
+		is.Equal(30.0, hvac.Temperature.Get())
+		is.True(hvac.Boost.IsActive())
+	})
+
+	t.Run("expires", func(t *testing.T) {
+		hvac.DecisionScore = 42
+		hvac.Boost.Until = time.Now().Add(-time.Second)
+		logic.TunePump(context.Background(), pump)
+		is.True(!hvac.Boost.IsActive())
+		is.Equal("HEAT", hvac.Mode.Get())
+		is.Equal(20.0, hvac.Temperature.Get())
+		is.Equal(0.0, hvac.DecisionScore)
+	})
+}
+
+func TestWindow(t *testing.T) {
+	is := is.New(t)
+	mqttClient := mocks.NewMockMqtt()
+
+	roomTemp := mocks.NewMockTemperatureSensor(mqttClient, "room_sensor")
+	pump := &models.Pump{
+		Units: []*models.Hvac{
+			models.NewHvacWithDefaultTopics(mqttClient, "room", roomTemp.Topic()).WithWindowSensors("zigbee2mqtt/window"),
+		},
+	}
+	hvac := pump.Units[0]
+	mockHvac := mocks.NewMockHvac(mqttClient, "room")
+	mocks.DesiredMinTemp(mqttClient, "room", 20)
+
+	t.Run("contact sensor", func(t *testing.T) {
+		roomTemp.Set(18)
+		mockHvac.SetMode("HEAT")
+		mqttClient.Publish("zigbee2mqtt/window", 0, false, `{"contact": false}`)
+		logic.TunePump(context.Background(), pump)
+		is.True(hvac.Window.Open)
+		is.Equal("OFF", hvac.Mode.Get())
+
+		mqttClient.Publish("zigbee2mqtt/window", 0, false, `{"contact": true}`)
+		logic.TunePump(context.Background(), pump)
+		is.True(!hvac.Window.Open)
+	})
+
+	t.Run("temperature drop", func(t *testing.T) {
+		mockHvac.SetMode("HEAT")
+		roomTemp.Set(21)
+		roomTemp.Set(19.5)
+		logic.TunePump(context.Background(), pump)
+		is.True(hvac.Window.Open)
+		is.Equal("OFF", hvac.Mode.Get())
+
+		// Stays paused for a while since we can't tell when the window gets closed.
+		logic.TunePump(context.Background(), pump)
+		is.True(hvac.Window.Open)
+
+		hvac.Window.Since = time.Now().Add(-time.Hour)
+		logic.TunePump(context.Background(), pump)
+		is.True(!hvac.Window.Open)
+	})
+}
+
+func TestPowerLimit(t *testing.T) {
+	is := is.New(t)
+	mqttClient := mocks.NewMockMqtt()
+
+	roomTemp := mocks.NewMockTemperatureSensor(mqttClient, "room_sensor")
+	otherTemp := mocks.NewMockTemperatureSensor(mqttClient, "other_sensor")
+	manualTemp := mocks.NewMockTemperatureSensor(mqttClient, "manual_sensor")
+	pump := &models.Pump{
+		Priority: []string{"room"},
+		Units: []*models.Hvac{
+			models.NewHvacWithDefaultTopics(mqttClient, "room", roomTemp.Topic()),
+			models.NewHvacWithDefaultTopics(mqttClient, "other", otherTemp.Topic()),
+			models.NewHvacWithDefaultTopics(mqttClient, "manual", manualTemp.Topic()),
+		},
+	}
+	site := models.NewSite([]*models.Pump{pump}, models.NewTariff(mqttClient, "", 1), models.NewAway(mqttClient, 12, 30))
+	site.PowerLimit = models.NewPowerLimit(mqttClient, "power", 9000, 1000)
+	room, other, manual := pump.Units[0], pump.Units[1], pump.Units[2]
+	mockRoom := mocks.NewMockHvac(mqttClient, "room")
+	mockOther := mocks.NewMockHvac(mqttClient, "other")
+	// Someone runs this one by hand, the power limit leaves it alone.
+	mocks.NewMockHvac(mqttClient, "manual").SetMode("HEAT")
+	mocks.Autopilot(mqttClient, "manual", false)
+	mqttClient.Publish("esphome/manual/fan_mode_command", 0, false, "HIGH")
+	manualTemp.Set(20.5)
+	mocks.DesiredMinTemp(mqttClient, "room", 20)
+	mocks.DesiredMinTemp(mqttClient, "other", 20)
+	roomTemp.Set(20.5)
+	otherTemp.Set(20.5)
+	// Both units are heating under the autopilot, the other one aiming high enough to want the fan at HIGH.
+	mockRoom.SetMode("HEAT")
+	mqttClient.Publish("esphome/room/target_temperature_command", 0, false, "21.0")
+	mockOther.SetMode("HEAT")
+	mqttClient.Publish("esphome/other/target_temperature_command", 0, false, "24.0")
+	logic.TuneSite(context.Background(), site)
+	is.Equal("LOW", room.Fan.Get())
+	is.Equal("HIGH", other.Fan.Get())
+
+	t.Run("slows fans down first", func(t *testing.T) {
+		mqttClient.Publish("power", 0, false, `{"power": 10000}`)
+		logic.TuneSite(context.Background(), site)
+		is.Equal("MEDIUM", other.Fan.Get()) // The strategy can't speed it up again.
+		logic.TuneSite(context.Background(), site)
+		is.Equal("AUTO", other.Fan.Get())
+		is.Equal("HEAT", other.Mode.Get())
+	})
+
+	t.Run("pauses units that aren't a priority", func(t *testing.T) {
+		logic.TuneSite(context.Background(), site)
+		is.Equal("OFF", other.Mode.Get())
+		is.True(other.Shed.Paused)
+		logic.TuneSite(context.Background(), site)
+		is.Equal("OFF", other.Mode.Get())
+		is.Equal("HEAT", room.Mode.Get())
+	})
+
+	t.Run("restores", func(t *testing.T) {
+		mqttClient.Publish("power", 0, false, `{"power": 8500}`)
+		logic.TuneSite(context.Background(), site)
+		is.True(other.Shed.Paused)
+		mqttClient.Publish("power", 0, false, `{"power": 5000}`)
+		logic.TuneSite(context.Background(), site)
+		is.True(!other.Shed.Paused)
+		logic.TuneSite(context.Background(), site)
+		is.Equal(models.Shed{}, other.Shed)
+	})
+
+	is.Equal("HEAT", manual.Mode.Get())
+	is.Equal("HIGH", manual.Fan.Get())
+	is.Equal(models.Shed{}, manual.Shed)
+}
+
+func TestLearnedStrategy(t *testing.T) {
+	is := is.New(t)
+	mqttClient := mocks.NewMockMqtt()
+
+	roomTemp := mocks.NewMockTemperatureSensor(mqttClient, "room_sensor")
+	outdoorTemp := mocks.NewMockTemperatureSensor(mqttClient, "outdoor_sensor")
+	pump := &models.Pump{
+		Units: []*models.Hvac{
+			models.NewHvacWithDefaultTopics(mqttClient, "room", roomTemp.Topic()),
+		},
+	}
+	site := models.NewSite([]*models.Pump{pump}, models.NewTariff(mqttClient, "", 1), models.NewAway(mqttClient, 12, 30))
+	site.SetOutdoorSensor(mqtt.NewJsonTemperatureSensor(mqttClient, outdoorTemp.Topic()))
+	hvac := pump.Units[0]
+	mocks.NewMockHvac(mqttClient, "room")
+	mocks.Strategy(mqttClient, "room", "learned")
+	mocks.DesiredMinTemp(mqttClient, "room", 20)
+	roomTemp.Set(22)
+	outdoorTemp.Set(2)
+
+	t.Run("waits while the room holds its temperature", func(t *testing.T) {
+		logic.TunePump(context.Background(), pump)
+		is.Equal("OFF", hvac.Mode.Get())
+	})
+
+	t.Run("starts ahead of time in a leaky room", func(t *testing.T) {
+		// Loses 2°C/h and needs 2h to gain a degree.
+		hvac.Thermal.Leak = 0.1
+		hvac.Thermal.Heating["AUTO"] = 0.5
+		logic.TunePump(context.Background(), pump)
+		is.Equal("HEAT", hvac.Mode.Get())
+		is.Equal(20.0, hvac.Temperature.Get())
+	})
+}
+
+func TestStartsAhead(t *testing.T) {
+	is := is.New(t)
+	mqttClient := mocks.NewMockMqtt()
+
+	roomTemp := mocks.NewMockTemperatureSensor(mqttClient, "room_sensor")
+	outdoorTemp := mocks.NewMockTemperatureSensor(mqttClient, "outdoor_sensor")
+	pump := &models.Pump{
+		Units: []*models.Hvac{
+			models.NewHvacWithDefaultTopics(mqttClient, "room", roomTemp.Topic()),
+		},
+	}
+	site := models.NewSite([]*models.Pump{pump}, models.NewTariff(mqttClient, "", 1), models.NewAway(mqttClient, 12, 30))
+	site.SetOutdoorSensor(mqtt.NewJsonTemperatureSensor(mqttClient, outdoorTemp.Topic()))
+	hvac := pump.Units[0]
+	mocks.NewMockHvac(mqttClient, "room")
+	mocks.DesiredMinTemp(mqttClient, "room", 20)
+	outdoorTemp.Set(2)
+	// Loses 0.1°C/h per °C over the outdoor temperature, and needs an hour to gain a degree.
+	hvac.Thermal.Leak = 0.1
+	hvac.Thermal.Heating["AUTO"] = 1
+
+	t.Run("waits while the room stays in range for longer than the unit needs", func(t *testing.T) {
+		roomTemp.Set(22.5) // 20.45°C in an hour.
+		logic.TunePump(context.Background(), pump)
+		is.Equal("OFF", hvac.Mode.Get())
+	})
+
+	t.Run("waits longer in a better insulated room", func(t *testing.T) {
+		hvac.Thermal.Leak = 0.05
+		roomTemp.Set(21.9) // 20.9°C in an hour.
+		logic.TunePump(context.Background(), pump)
+		is.Equal("OFF", hvac.Mode.Get())
+		hvac.Thermal.Leak = 0.1
+	})
+
+	t.Run("starts once the room would leave the range before the unit catches up", func(t *testing.T) {
+		roomTemp.Set(21.9) // 19.91°C in an hour, while the threshold is at 21°C.
+		logic.TunePump(context.Background(), pump)
+		is.Equal("HEAT", hvac.Mode.Get())
+		is.Equal(20.0, hvac.Temperature.Get())
+	})
+}
+
+func TestLearnRates(t *testing.T) {
+	is := is.New(t)
+	store, err := tsdb.Open(t.TempDir(), 24*time.Hour)
+	is.NoErr(err)
+	mqtt.History = store
+	defer func() { mqtt.History = nil }()
+	// The unit has been heating for 2 hours and the room gained a degree over the last 40 minutes.
+	now := time.Now()
+	is.NoErr(store.Append("esphome/room/mode_state", now.Add(-3*time.Hour), "OFF"))
+	is.NoErr(store.Append("esphome/room/mode_state", now.Add(-2*time.Hour), "HEAT"))
+	is.NoErr(store.Append("esphome/room/fan_mode_state", now.Add(-2*time.Hour), "AUTO"))
+	is.NoErr(store.Append("sensors/room_sensor/temperature", now.Add(-50*time.Minute), "19"))
+	is.NoErr(store.Append("sensors/room_sensor/temperature", now.Add(-10*time.Minute), "20"))
+
+	mqttClient := mocks.NewMockMqtt()
+	pump := &models.Pump{
+		Units: []*models.Hvac{
+			models.NewHvacWithDefaultTopics(mqttClient, "room", "sensors/room_sensor/temperature"),
+		},
+	}
+	hvac := pump.Units[0]
+	mocks.Autopilot(mqttClient, "room", false)
+
+	logic.TunePump(context.Background(), pump)
+	is.True(hvac.Thermal.WarmUp > 1)
+	is.True(hvac.Thermal.Heating["AUTO"] > 1)
+
+	t.Run("measures each hour once", func(t *testing.T) {
+		learned := hvac.Thermal.WarmUp
+		published := 0
+		mqttClient.Subscribe("air3/room/thermal_model", 0, func(c paho.Client, m paho.Message) {
+			if !m.Retained() {
+				published++
+			}
+		})
+		logic.TunePump(context.Background(), pump)
+		is.Equal(learned, hvac.Thermal.WarmUp)
+		is.Equal(0, published)
+	})
+
+	t.Run("restores the retained model", func(t *testing.T) {
+		restarted := models.NewHvacWithDefaultTopics(mqttClient, "room", "sensors/room_sensor/temperature")
+		is.Equal(1.0, restarted.Thermal.WarmUp) // Left to the main loop.
+		utils.MainLoop.RunPending()
+		is.Equal(hvac.Thermal.WarmUp, restarted.Thermal.WarmUp)
+		is.Equal(hvac.Thermal.Heating, restarted.Thermal.Heating)
+		is.True(hvac.Thermal.Measured.Equal(restarted.Thermal.Measured))
+	})
+}
+
+func TestRepublishOnBirth(t *testing.T) {
+	is := is.New(t)
+	mqttClient := mocks.NewMockMqtt()
+
+	roomTemp := mocks.NewMockTemperatureSensor(mqttClient, "room_sensor")
+	pump := &models.Pump{
+		Units: []*models.Hvac{
+			models.NewHvacWithDefaultTopics(mqttClient, "room", roomTemp.Topic()),
+		},
+	}
+	site := models.NewSite([]*models.Pump{pump}, models.NewTariff(mqttClient, "", 1), models.NewAway(mqttClient, 12, 30))
+	utils.MainLoop.RunPending()
+	published := 0
+	mqttClient.Subscribe("homeassistant/climate/air3/room/config", 0, func(c paho.Client, m paho.Message) {
+		if !m.Retained() {
+			published++
+		}
+	})
+	logic.RepublishOnBirth(mqttClient, site)
+
+	mqttClient.Publish("homeassistant/status", 0, false, "offline")
+	mqttClient.Publish("homeassistant/status", 0, false, "online")
+	is.Equal(0, published) // Left to the main loop.
+
+	utils.MainLoop.RunPending()
+	is.Equal(1, published)
+}
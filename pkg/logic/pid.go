@@ -0,0 +1,117 @@
+package logic
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/nanassito/air/pkg/models"
+)
+
+const (
+	pidKp = 1.5
+	// Per hour of error.
+	pidKi = 0.5
+	// In hours, applied to the rate of change of the room temperature.
+	pidKd          = 1.0
+	pidMaxIntegral = 4.0
+	// Longest gap between two runs of the same control period. Past it, the controller wasn't driving the unit, e.g.
+	// because another strategy was selected, and it starts over instead of carrying the old integral on.
+	pidMaxStep = 5 * time.Minute
+	pidMaxHeat = 30.0
+	pidMinCold = 17.0
+	// Aiming higher only keeps the unit idle.
+	pidMaxCold = 31.0
+)
+
+type pidState struct {
+	mode     string
+	integral float64
+	last     time.Time
+}
+
+// pidStrategy starts like the bang-bang strategy, then steers the target temperature of the unit with a PID
+// controller on the room temperature, aiming half a degree inside the comfort range.
+type pidStrategy struct {
+	states map[string]*pidState
+}
+
+func newPidStrategy() pidStrategy {
+	return pidStrategy{states: map[string]*pidState{}}
+}
+
+// output is how far past the setpoint the unit should aim. sign is 1 when heating and -1 when cooling.
+func (s pidStrategy) output(hvac *models.Hvac, setpoint float64, current float64, sign float64) float64 {
+	now := time.Now()
+	state, ok := s.states[hvac.Name]
+	if !ok || state.mode != hvac.Mode.Get() || now.Sub(state.last) > pidMaxStep {
+		state = &pidState{mode: hvac.Mode.Get()}
+		s.states[hvac.Name] = state
+	}
+	err := sign * (setpoint - current)
+	if !state.last.IsZero() {
+		step := now.Sub(state.last).Hours()
+		state.integral = math.Max(-pidMaxIntegral, math.Min(pidMaxIntegral, state.integral+err*step))
+	}
+	state.last = now
+	rate := hvac.AutoPilot.Sensors.Air.GetRate()
+	return pidKp*err + pidKi*state.integral - pidKd*sign*rate
+}
+
+// steer sets the target temperature, rounded to what the unit accepts.
+func (s pidStrategy) steer(ctx context.Context, hvac *models.Hvac, target float64) {
+	target = math.Round(target*2) / 2
+	if target != hvac.Temperature.Get() {
+		decide(hvac, "Steering the target temperature", "target", target)
+		hvac.Temperature.Set(ctx, target)
+	}
+}
+
+func (s pidStrategy) StartHeat(ctx context.Context, hvac *models.Hvac, pump *models.Pump) {
+	delete(s.states, hvac.Name)
+	bangBangStrategy{}.StartHeat(ctx, hvac, pump)
+}
+
+func (s pidStrategy) TuneHeat(ctx context.Context, hvac *models.Hvac, pump *models.Pump) {
+	current, err := getCurrentTemp(hvac)
+	if err != nil {
+		L.Error(err.Error(), "hvac", hvac.Name)
+		return
+	}
+	if current > hvac.DesiredMin()+hvac.AutoPilot.Tuning.ShutdownBand.Get() {
+		if decideStop(ctx, hvac, pump, "It's way too hot, shutting down") {
+			delete(s.states, hvac.Name)
+		}
+		return
+	}
+	setpoint := hvac.DesiredMin() + 0.5
+	output := s.output(hvac, setpoint, current, 1)
+	s.steer(ctx, hvac, math.Max(hvac.AutoPilot.Tuning.HeatFloor.Get(), math.Min(pidMaxHeat, setpoint+output)))
+}
+
+func (s pidStrategy) StartCold(ctx context.Context, hvac *models.Hvac, pump *models.Pump) {
+	delete(s.states, hvac.Name)
+	bangBangStrategy{}.StartCold(ctx, hvac, pump)
+}
+
+func (s pidStrategy) TuneCold(ctx context.Context, hvac *models.Hvac, pump *models.Pump) {
+	current, err := getCurrentTemp(hvac)
+	if err != nil {
+		L.Error(err.Error(), "hvac", hvac.Name)
+		return
+	}
+	if current < hvac.DesiredMax()-hvac.AutoPilot.Tuning.ShutdownBand.Get() {
+		if decideStop(ctx, hvac, pump, "It's way too cold, shutting down") {
+			delete(s.states, hvac.Name)
+		}
+		return
+	}
+	setpoint := hvac.DesiredMax() - 0.5
+	output := s.output(hvac, setpoint, current, -1)
+	s.steer(ctx, hvac, math.Max(pidMinCold, math.Min(pidMaxCold, setpoint-output)))
+}
+
+func (s pidStrategy) Stop(ctx context.Context, hvac *models.Hvac, pump *models.Pump) {
+	delete(s.states, hvac.Name)
+	stop(ctx, hvac, pump)
+}
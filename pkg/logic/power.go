@@ -0,0 +1,109 @@
+package logic
+
+import (
+	"context"
+
+	"github.com/nanassito/air/pkg/models"
+)
+
+type shedCandidate struct {
+	hvac *models.Hvac
+	pump *models.Pump
+}
+
+// shedOrder lists every unit, the ones that aren't a priority for their pump first.
+func shedOrder(site *models.Site) []shedCandidate {
+	candidates := make([]shedCandidate, 0)
+	for _, priority := range []bool{false, true} {
+		for _, pump := range site.Pumps {
+			for _, hvac := range pump.Units {
+				if pump.IsPriority(hvac) == priority {
+					candidates = append(candidates, shedCandidate{hvac, pump})
+				}
+			}
+		}
+	}
+	return candidates
+}
+
+// shedLoad takes one step towards consuming less: slowing a fan down, or pausing a unit that isn't a priority. Units
+// run by hand, with the autopilot disabled, are left alone.
+func shedLoad(ctx context.Context, site *models.Site, power float64) {
+	candidates := make([]shedCandidate, 0)
+	for _, c := range shedOrder(site) {
+		if c.hvac.AutoPilot.Enabled.Get() {
+			candidates = append(candidates, c)
+		}
+	}
+	for _, c := range candidates {
+		if fan := c.hvac.Fan.Get(); isRunning(c.hvac.Mode.Get()) && (fan == "HIGH" || fan == "MEDIUM") {
+			if c.hvac.Shed.Fan == "" {
+				c.hvac.Shed.Fan = fan
+			}
+			decide(c.hvac, "Power is over the limit, slowing the fan down", "power", power)
+			c.hvac.DecreaseFanSpeed(ctx)
+			if !c.hvac.Fan.IsAcknowledged() {
+				L.Warn("The unit didn't slow its fan down, trying again on the next run", "hvac", c.hvac.Name)
+				return
+			}
+			// Keep the strategies from speeding it up again on their next run.
+			c.hvac.Shed.Ceiling = c.hvac.Fan.Get()
+			return
+		}
+	}
+	for _, c := range candidates {
+		if !isRunning(c.hvac.Mode.Get()) || c.pump.IsPriority(c.hvac) {
+			continue
+		}
+		GetStrategy(c.hvac).Stop(ctx, c.hvac, c.pump)
+		if !isRunning(c.hvac.Mode.Get()) {
+			decide(c.hvac, "Power is over the limit, pausing", "power", power)
+			c.hvac.Boost = models.Boost{}
+			c.hvac.Shed.Paused = true
+			return
+		}
+	}
+	L.Warn("Power is over the limit but there is nothing left to shed", "power", power, "limit", site.PowerLimit.Limit)
+}
+
+// restoreLoad gives back one thing that was shed, paused units first.
+func restoreLoad(ctx context.Context, site *models.Site, power float64) {
+	candidates := shedOrder(site)
+	for i := len(candidates) - 1; i >= 0; i-- {
+		if hvac := candidates[i].hvac; hvac.Shed.Paused {
+			decide(hvac, "Power is back under the limit, resuming", "power", power)
+			hvac.Shed.Paused = false
+			return
+		}
+	}
+	for i := len(candidates) - 1; i >= 0; i-- {
+		if hvac := candidates[i].hvac; hvac.Shed.Fan != "" {
+			decide(hvac, "Power is back under the limit, restoring the fan speed", "power", power, "fan", hvac.Shed.Fan)
+			hvac.Shed.Ceiling = ""
+			if isRunning(hvac.Mode.Get()) {
+				hvac.SetFan(ctx, hvac.Shed.Fan)
+			}
+			hvac.Shed.Fan = ""
+			return
+		}
+	}
+}
+
+// limitPower sheds or restores units, one step per run to give the meter time to reflect it.
+func limitPower(ctx context.Context, site *models.Site) {
+	if site.PowerLimit == nil {
+		return
+	}
+	power, err := site.PowerLimit.Meter.Get()
+	if err != nil {
+		L.Warn("No reading from the power meter yet")
+		return
+	}
+	L.Info("House power", "power", power, "limit", site.PowerLimit.Limit)
+	switch {
+	case power > site.PowerLimit.Limit:
+		shedLoad(ctx, site, power)
+	case power < site.PowerLimit.Limit-site.PowerLimit.Margin:
+		restoreLoad(ctx, site, power)
+	}
+}
@@ -0,0 +1,24 @@
+package logic
+
+import (
+	"time"
+
+	"github.com/nanassito/air/pkg/models"
+)
+
+// recordRuntime accounts for how long the units and the pump ran since the previous run.
+func recordRuntime(pump *models.Pump) {
+	now := time.Now()
+	for _, hvac := range pump.Units {
+		hvac.Runtime.Record(now, hvac.Mode.Get(), hvac.Fan.Get(), 0)
+	}
+	power := 0.0
+	if pump.PowerMeter != nil {
+		if watts, err := pump.PowerMeter.Get(); err == nil {
+			power = watts
+		} else {
+			L.Warn("No power reading for the pump yet", "pump", pump.Name)
+		}
+	}
+	pump.Runtime.Record(now, runningMode(pump), "", power)
+}
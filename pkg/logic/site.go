@@ -0,0 +1,49 @@
+package logic
+
+import (
+	"context"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+
+	"github.com/nanassito/air/pkg/discovery"
+	"github.com/nanassito/air/pkg/models"
+	"github.com/nanassito/air/pkg/utils"
+)
+
+// RepublishOnBirth declares everything to Home Assistant again, along with the current states, whenever it comes
+// back online. The work happens on the main loop since it reads and pings every unit.
+func RepublishOnBirth(mqttClient paho.Client, site *models.Site) {
+	discovery.OnBirth(mqttClient, func() {
+		utils.MainLoop.Do(func() {
+			L.Info("Home Assistant is online, publishing the discovery again.")
+			site.Away.PublishDiscovery()
+			site.Away.Ping()
+			for _, pump := range site.Pumps {
+				pump.PublishRuntimeDiscovery(mqttClient)
+			}
+			for _, hvac := range site.Units() {
+				hvac.PublishDiscovery()
+				PublishStrategySelect(mqttClient, hvac)
+				hvac.Ping()
+			}
+		})
+	})
+}
+
+func TuneSite(ctx context.Context, site *models.Site) {
+	site.Away.Expire()
+	site.Away.Ping()
+	if site.Away.IsActive() {
+		L.Info("Away mode is active", "return", site.Away.Return.Get())
+	}
+	switch level := site.Tariff.Level(); level {
+	case models.TariffCheap:
+		L.Info("Electricity is cheap, pre-conditioning the rooms", "tariff", level, "band", site.Tariff.Band)
+	case models.TariffPeak:
+		L.Info("Electricity is expensive, widening the comfort range", "tariff", level, "band", site.Tariff.Band)
+	}
+	limitPower(ctx, site)
+	for _, pump := range site.Pumps {
+		TunePump(ctx, pump)
+	}
+}
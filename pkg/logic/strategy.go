@@ -0,0 +1,101 @@
+package logic
+
+import (
+	"context"
+	"sort"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+
+	"github.com/nanassito/air/pkg/discovery"
+	"github.com/nanassito/air/pkg/models"
+)
+
+// Strategy is an algorithm driving a single hvac unit once the pump allows a given mode.
+type Strategy interface {
+	StartHeat(ctx context.Context, hvac *models.Hvac, pump *models.Pump)
+	TuneHeat(ctx context.Context, hvac *models.Hvac, pump *models.Pump)
+	StartCold(ctx context.Context, hvac *models.Hvac, pump *models.Pump)
+	TuneCold(ctx context.Context, hvac *models.Hvac, pump *models.Pump)
+	Stop(ctx context.Context, hvac *models.Hvac, pump *models.Pump)
+}
+
+var strategies = map[string]Strategy{}
+
+func RegisterStrategy(name string, strategy Strategy) {
+	if _, ok := strategies[name]; ok {
+		panic("strategy registered twice: " + name)
+	}
+	strategies[name] = strategy
+	models.RegisterStrategyName(name)
+}
+
+func StrategyNames() []string {
+	names := make([]string, 0, len(strategies))
+	for name := range strategies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// GetStrategy returns the strategy selected for the hvac, falling back to the default one.
+func GetStrategy(hvac *models.Hvac) Strategy {
+	if strategy, ok := strategies[hvac.AutoPilot.Strategy.Get()]; ok {
+		return strategy
+	}
+	L.Warn("Unknown strategy, using the default one", "strategy", hvac.AutoPilot.Strategy.Get(), "hvac", hvac.Name)
+	return strategies[models.DefaultStrategy]
+}
+
+// PublishStrategySelect exposes the strategy of the hvac as a select entity in Home Assistant.
+func PublishStrategySelect(mqttClient paho.Client, hvac *models.Hvac) {
+	discovery.Publish(mqttClient, hvac.Name+"_strategy", discovery.Select{
+		Entity:       hvac.DiscoveryEntity("Strategy", "strategy", "mdi:brain"),
+		CommandTopic: "air3/" + hvac.Name + "/autopilot/strategy/command",
+		StateTopic:   "air3/" + hvac.Name + "/autopilot/strategy/state",
+		Options:      StrategyNames(),
+	})
+}
+
+func stop(ctx context.Context, hvac *models.Hvac, pump *models.Pump) bool {
+	if !setMode(ctx, hvac, pump, "OFF") {
+		return false
+	}
+	hvac.DecisionScore = 0
+	return true
+}
+
+// decideStop turns the hvac off and records the decision behind it, only if it actually stopped.
+func decideStop(ctx context.Context, hvac *models.Hvac, pump *models.Pump, decision string, args ...any) bool {
+	if !decideMode(ctx, hvac, pump, "OFF", decision, args...) {
+		return false
+	}
+	hvac.DecisionScore = 0
+	return true
+}
+
+// scoreStrategy is the original algorithm, accumulating a decision score before tweaking the target temperature.
+type scoreStrategy struct{}
+
+func (scoreStrategy) StartHeat(ctx context.Context, hvac *models.Hvac, pump *models.Pump) {
+	StartHeat(ctx, hvac, pump)
+}
+func (scoreStrategy) TuneHeat(ctx context.Context, hvac *models.Hvac, pump *models.Pump) {
+	TuneHeat(ctx, hvac, pump)
+}
+func (scoreStrategy) StartCold(ctx context.Context, hvac *models.Hvac, pump *models.Pump) {
+	StartCold(ctx, hvac, pump)
+}
+func (scoreStrategy) TuneCold(ctx context.Context, hvac *models.Hvac, pump *models.Pump) {
+	TuneCold(ctx, hvac, pump)
+}
+func (scoreStrategy) Stop(ctx context.Context, hvac *models.Hvac, pump *models.Pump) {
+	stop(ctx, hvac, pump)
+}
+
+func init() {
+	RegisterStrategy(models.DefaultStrategy, scoreStrategy{})
+	RegisterStrategy("bangbang", bangBangStrategy{})
+	RegisterStrategy("pid", newPidStrategy())
+	RegisterStrategy("learned", learnedStrategy{})
+}
@@ -0,0 +1,85 @@
+package logic
+
+import (
+	"math"
+	"time"
+
+	"github.com/nanassito/air/pkg/models"
+)
+
+// learn moves a learned rate a bit towards the latest measurement.
+func learn(learned float64, measured float64) float64 {
+	return 0.9*learned + 0.1*measured
+}
+
+// startAhead tells whether the learned thermal model expects the room to leave the comfort range before the unit
+// would have time to bring it back, should it start now. Without a learned heat leak, it leaves the decision to the
+// thresholds of the strategy.
+func startAhead(hvac *models.Hvac, mode string) bool {
+	if hvac.Thermal.Leak == 0 || hvac.AutoPilot.Sensors.Outdoor == nil {
+		return false
+	}
+	forecast := hvac.Forecast(hvac.StartLead(mode, "AUTO"))
+	if mode == "HEAT" {
+		return forecast <= hvac.DesiredMin()
+	}
+	return forecast >= hvac.DesiredMax()
+}
+
+// learnRates refines the thermal model of the room, once the unit stayed long enough in the same state for the
+// whole sensor history to reflect it. Each hour of history is measured once, so that a steady period counts as
+// many times as it lasted hours rather than once per run.
+func learnRates(hvac *models.Hvac) {
+	model := &hvac.Thermal
+	if changed, ok := hvac.Mode.LastChange(); !ok || time.Since(changed) < time.Hour {
+		return
+	}
+	if time.Since(model.Measured) < time.Hour {
+		return
+	}
+	rate := hvac.AutoPilot.Sensors.Air.GetRate()
+	fan := hvac.Fan.Get()
+	steadyFan := hvac.Fan.UnchangedFor() >= time.Hour
+	switch hvac.Mode.Get() {
+	case "HEAT":
+		if rate <= 0 {
+			return
+		}
+		model.WarmUp = learn(model.WarmUp, rate)
+		if steadyFan {
+			model.Heating[fan] = learn(model.HeatRate(fan), rate)
+		}
+	case "COOL":
+		if rate >= 0 {
+			return
+		}
+		model.CoolDown = learn(model.CoolDown, -rate)
+		if steadyFan {
+			model.Cooling[fan] = learn(model.CoolRate(fan), -rate)
+		}
+	case "OFF":
+		outdoor := hvac.AutoPilot.Sensors.Outdoor
+		if outdoor == nil {
+			return
+		}
+		outside, err := outdoor.Get()
+		current, err2 := hvac.AutoPilot.Sensors.Air.Get()
+		// Too close to the outdoor temperature and the sensor noise dominates.
+		if err != nil || err2 != nil || math.Abs(current-outside) < 2 {
+			return
+		}
+		leak := -rate / (current - outside)
+		if leak < 0 {
+			return
+		}
+		if model.Leak == 0 {
+			model.Leak = leak
+		} else {
+			model.Leak = learn(model.Leak, leak)
+		}
+	default:
+		return
+	}
+	model.Measured = time.Now()
+	hvac.PublishThermalModel()
+}
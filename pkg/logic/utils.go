@@ -1,13 +1,18 @@
 package logic
 
 import (
+	"context"
 	"errors"
+	"math"
+	"sort"
+
+	"github.com/golang-collections/collections/set"
 
 	"github.com/nanassito/air/pkg/models"
 	"github.com/nanassito/air/pkg/utils"
 )
 
-var L = utils.Logger
+var L = utils.NewLogger("logic")
 
 func getCurrentTemp(hvac *models.Hvac) (float64, error) {
 	current, err := hvac.AutoPilot.Sensors.Air.Get()
@@ -18,31 +23,93 @@ func getCurrentTemp(hvac *models.Hvac) (float64, error) {
 	return current, nil
 }
 
-func TunePump(pump *models.Pump) {
-	usableModes := pump.GetUsableModes()
-	for _, hvac := range pump.Units {
+// decide logs what the autopilot decided for the hvac and keeps it around for Home Assistant.
+func decide(hvac *models.Hvac, decision string, args ...any) {
+	L.Info(decision, append([]any{"hvac", hvac.Name}, args...)...)
+	hvac.Decide(decision)
+}
+
+// decideMode changes the mode of the hvac and records the decision behind it, only if the change was allowed.
+func decideMode(ctx context.Context, hvac *models.Hvac, pump *models.Pump, mode string, decision string, args ...any) bool {
+	if !setMode(ctx, hvac, pump, mode) {
+		L.Info("Not carried out: "+decision, append([]any{"hvac", hvac.Name, "mode", mode}, args...)...)
+		return false
+	}
+	decide(hvac, decision, args...)
+	return true
+}
+
+// startOrder sorts the units so the rooms furthest from their setpoint get to start first.
+func startOrder(pump *models.Pump) []*models.Hvac {
+	units := make([]*models.Hvac, len(pump.Units))
+	copy(units, pump.Units)
+	sort.SliceStable(units, func(i, j int) bool {
+		iHeat, iCool := unitDemand(units[i])
+		jHeat, jCool := unitDemand(units[j])
+		return math.Max(iHeat, iCool) > math.Max(jHeat, jCool)
+	})
+	return units
+}
+
+func runStrategy(ctx context.Context, strategy Strategy, hvac *models.Hvac, pump *models.Pump, usableModes *set.Set) {
+	if usableModes.Has("HEAT") {
+		if hvac.Mode.Get() == "OFF" {
+			strategy.StartHeat(ctx, hvac, pump)
+		}
+		if hvac.Mode.Get() == "HEAT" {
+			strategy.TuneHeat(ctx, hvac, pump)
+		}
+	}
+	if usableModes.Has("COOL") {
+		if hvac.Mode.Get() == "OFF" {
+			strategy.StartCold(ctx, hvac, pump)
+		}
+		if hvac.Mode.Get() == "COOL" {
+			strategy.TuneCold(ctx, hvac, pump)
+		}
+	}
+}
+
+func TunePump(ctx context.Context, pump *models.Pump) {
+	// Apply what the mqtt and http handlers queued since the last run.
+	utils.MainLoop.RunPending()
+	recordCompressor(pump)
+	recordRuntime(pump)
+	usableModes := Arbitrate(ctx, pump)
+	for _, hvac := range startOrder(pump) {
+		if err := ctx.Err(); err != nil {
+			L.Warn("Interrupting the autopilot run", "pump", pump.Name, "err", err)
+			return
+		}
 		hvac.Log()
+		learnRates(hvac)
 		if hvac.AutoPilot.Enabled.Get() {
 			L.Info("Autopilot is enabled on this hvac", "hvac", hvac.Name)
-			if usableModes.Has("HEAT") {
-				if hvac.Mode.Get() == "OFF" {
-					StartHeat(hvac)
-				}
-				if hvac.Mode.Get() == "HEAT" {
-					TuneHeat(hvac, pump)
-				}
-			}
-			if usableModes.Has("COOL") {
-				if hvac.Mode.Get() == "OFF" {
-					StartCold(hvac)
-				}
-				if hvac.Mode.Get() == "COOL" {
-					TuneCold(hvac, pump)
-				}
+			if checkWindow(ctx, hvac, pump) {
+				L.Info("Hvac is paused while the window is open", "hvac", hvac.Name)
+			} else if hvac.Shed.Paused {
+				L.Info("Hvac is paused to stay under the power limit", "hvac", hvac.Name)
+			} else if runBoost(ctx, hvac, pump, usableModes) {
+				L.Info("Boost took care of this hvac", "hvac", hvac.Name, "until", hvac.Boost.Until)
+			} else {
+				runStrategy(ctx, GetStrategy(hvac), hvac, pump, usableModes)
 			}
 		} else {
 			L.Info("Autopilot is disabled on this hvac", "hvac", hvac.Name)
+			hvac.Actions.CancelAll()
+			hvac.Boost = models.Boost{}
+		}
+		if !isRunning(hvac.Mode.Get()) {
+			hvac.Actions.CancelAll()
 		}
 		hvac.Ping()
+		hvac.RecordDecisionScore()
+		hvac.PublishDiagnostics(usableModes)
+		hvac.PublishAction()
+		hvac.PublishRuntime()
+		// An idle pump allows every mode, until the first unit to start picks one for the others.
+		if mode := pump.Arbitration.Mode; isRunning(mode) && usableModes.Has("HEAT") && usableModes.Has("COOL") {
+			usableModes = set.New("OFF", mode)
+		}
 	}
 }
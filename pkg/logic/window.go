@@ -0,0 +1,56 @@
+package logic
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/nanassito/air/pkg/models"
+)
+
+const (
+	// Heating rooms don't lose that much heat that quickly unless a window is open.
+	windowDrop       = 1.0
+	windowDropWithin = 10 * time.Minute
+	// Without a contact sensor we can't tell when the window gets closed so we just pause for a while.
+	windowPause = 15 * time.Minute
+)
+
+func detectWindow(hvac *models.Hvac) (bool, string) {
+	for _, sensor := range hvac.Window.Sensors {
+		if sensor.IsOpen() {
+			return true, "contact sensor"
+		}
+	}
+	if hvac.Mode.Get() == "HEAT" {
+		current, err := hvac.AutoPilot.Sensors.Air.Get()
+		if drop := hvac.AutoPilot.Sensors.Air.MaxSince(windowDropWithin) - current; err == nil && drop >= windowDrop {
+			return true, fmt.Sprintf("temperature dropped by %.1f°C", drop)
+		}
+	}
+	if hvac.Window.Open && hvac.Window.Reason != "contact sensor" && time.Since(hvac.Window.Since) < windowPause {
+		return true, hvac.Window.Reason
+	}
+	return false, ""
+}
+
+// checkWindow pauses the unit while a window is open. It returns true while paused.
+func checkWindow(ctx context.Context, hvac *models.Hvac, pump *models.Pump) bool {
+	open, reason := detectWindow(hvac)
+	if open != hvac.Window.Open {
+		hvac.Window.Open = open
+		hvac.Window.Since = time.Now()
+		hvac.Window.Reason = reason
+		hvac.PublishWindow()
+		if open {
+			decide(hvac, "Window is open, pausing", "reason", reason)
+		} else {
+			decide(hvac, "Window is closed, resuming")
+		}
+	}
+	if open && isRunning(hvac.Mode.Get()) {
+		hvac.Boost = models.Boost{}
+		GetStrategy(hvac).Stop(ctx, hvac, pump)
+	}
+	return open
+}
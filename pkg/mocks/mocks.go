@@ -4,11 +4,14 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"sort"
 	"strconv"
 	"time"
 
 	paho "github.com/eclipse/paho.mqtt.golang"
 	"github.com/nanassito/air/pkg/mqtt"
+	"github.com/nanassito/air/pkg/scheduler"
+	"github.com/nanassito/air/pkg/utils"
 )
 
 type token struct{}
@@ -25,8 +28,9 @@ func (t *token) Done() <-chan struct{} {
 func (t *token) Error() error { return nil }
 
 type message struct {
-	topic   string
-	payload []byte
+	topic    string
+	payload  []byte
+	retained bool
 }
 
 func (m *message) Duplicate() bool {
@@ -38,7 +42,7 @@ func (m *message) Qos() byte {
 }
 
 func (m *message) Retained() bool {
-	return false
+	return m.retained
 }
 
 func (m *message) Topic() string {
@@ -57,13 +61,16 @@ func (m *message) Ack() {
 }
 
 // Mocks paho.Client but with logic to match the rest of the infra.
+// Like a broker, it keeps the retained messages and replays them to new subscribers.
 type MockMqtt struct {
-	router map[string][]paho.MessageHandler
+	router   map[string][]paho.MessageHandler
+	retained map[string][]byte
 }
 
 func NewMockMqtt() *MockMqtt {
 	return &MockMqtt{
-		router: make(map[string][]paho.MessageHandler),
+		router:   make(map[string][]paho.MessageHandler),
+		retained: make(map[string][]byte),
 	}
 }
 
@@ -83,6 +90,13 @@ func (m MockMqtt) Publish(topic string, qos byte, retained bool, payload interfa
 	default:
 		panic("invalid message type")
 	}
+	if retained {
+		if len(data) == 0 {
+			delete(m.retained, topic)
+		} else {
+			m.retained[topic] = data
+		}
+	}
 	if callbacks, ok := m.router[topic]; ok {
 		for _, callback := range callbacks {
 			callback(m, &message{topic: topic, payload: data})
@@ -95,6 +109,9 @@ func (m MockMqtt) Subscribe(topic string, qos byte, callback paho.MessageHandler
 		m.router[topic] = make([]paho.MessageHandler, 0)
 	}
 	m.router[topic] = append(m.router[topic], callback)
+	if data, ok := m.retained[topic]; ok {
+		callback(m, &message{topic: topic, payload: data, retained: true})
+	}
 	return &token{}
 }
 func (m MockMqtt) SubscribeMultiple(filters map[string]byte, callback paho.MessageHandler) paho.Token {
@@ -128,20 +145,38 @@ func NewMockTemperatureSensor(mockMqtt *MockMqtt, name string) *MockTemperatureS
 	}
 }
 
+// command publishes a command the way Home Assistant does, then runs it on the main loop like air3 would.
+func command(mqttClient *MockMqtt, topic string, payload string) {
+	mqttClient.Publish(topic, 0, true, payload)
+	utils.MainLoop.RunPending()
+}
+
 func Autopilot(mqttClient *MockMqtt, room string, enabled bool) {
 	mode := "off"
 	if enabled {
 		mode = "auto"
 	}
-	mqttClient.Publish("air3/"+room+"/autopilot/mode/command", 0, true, mode)
+	command(mqttClient, "air3/"+room+"/autopilot/mode/command", mode)
 }
 
 func DesiredMinTemp(mqttClient *MockMqtt, room string, temp float64) {
-	mqttClient.Publish("air3/"+room+"/autopilot/minTemp/command", 0, true, strconv.FormatFloat(temp, 'f', 1, 64))
+	command(mqttClient, "air3/"+room+"/autopilot/minTemp/command", strconv.FormatFloat(temp, 'f', 1, 64))
 }
 
 func DesiredMaxTemp(mqttClient *MockMqtt, room string, temp float64) {
-	mqttClient.Publish("air3/"+room+"/autopilot/maxTemp/command", 0, true, strconv.FormatFloat(temp, 'f', 1, 64))
+	command(mqttClient, "air3/"+room+"/autopilot/maxTemp/command", strconv.FormatFloat(temp, 'f', 1, 64))
+}
+
+func Strategy(mqttClient *MockMqtt, room string, strategy string) {
+	command(mqttClient, "air3/"+room+"/autopilot/strategy/command", strategy)
+}
+
+func Tuning(mqttClient *MockMqtt, room string, parameter string, value float64) {
+	command(mqttClient, "air3/"+room+"/tuning/"+parameter+"/command", strconv.FormatFloat(value, 'f', -1, 64))
+}
+
+func Boost(mqttClient *MockMqtt, room string) {
+	mqttClient.Publish("air3/"+room+"/boost/command", 0, false, "PRESS")
 }
 
 type MockHvac struct {
@@ -186,3 +221,55 @@ func NewMockHvac(mockMqtt *MockMqtt, name string) *MockHvac {
 	hvac.SetMode("OFF")
 	return &hvac
 }
+
+type fakeTimer struct {
+	at      time.Time
+	f       func()
+	stopped bool
+}
+
+func (t *fakeTimer) Stop() bool {
+	wasPending := !t.stopped
+	t.stopped = true
+	return wasPending
+}
+
+// FakeClock only moves forward when Advance is called, firing the due timers synchronously.
+type FakeClock struct {
+	now    time.Time
+	timers []*fakeTimer
+}
+
+func NewFakeClock() *FakeClock {
+	return &FakeClock{now: time.Date(2023, 7, 1, 12, 0, 0, 0, time.UTC)}
+}
+
+func (c *FakeClock) Now() time.Time { return c.now }
+
+func (c *FakeClock) AfterFunc(d time.Duration, f func()) scheduler.Timer {
+	t := &fakeTimer{at: c.now.Add(d), f: f}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+func (c *FakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+	due := make([]*fakeTimer, 0)
+	pending := make([]*fakeTimer, 0, len(c.timers))
+	for _, t := range c.timers {
+		if t.stopped {
+			continue
+		}
+		if t.at.After(c.now) {
+			pending = append(pending, t)
+		} else {
+			due = append(due, t)
+		}
+	}
+	c.timers = pending
+	sort.Slice(due, func(i, j int) bool { return due[i].at.Before(due[j].at) })
+	for _, t := range due {
+		t.stopped = true
+		t.f()
+	}
+}
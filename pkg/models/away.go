@@ -0,0 +1,148 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+
+	"github.com/nanassito/air/pkg/discovery"
+	"github.com/nanassito/air/pkg/mqtt"
+)
+
+var returnLayouts = []string{time.RFC3339, "2006-01-02T15:04", "2006-01-02 15:04"}
+
+// Away overrides the comfort range of every unit with protection limits while nobody is home.
+type Away struct {
+	Enabled *mqtt.ControlledValue[bool]
+	// When we are expected back, empty if unknown.
+	Return          *mqtt.ControlledValue[string]
+	FrostProtection float64
+	HeatProtection  float64
+	mqtt            paho.Client
+}
+
+func (a *Away) ReturnAt() (time.Time, bool) {
+	for _, layout := range returnLayouts {
+		if t, err := time.ParseInLocation(layout, a.Return.Get(), time.Local); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func (a *Away) IsActive() bool {
+	if !a.Enabled.Get() {
+		return false
+	}
+	returnAt, ok := a.ReturnAt()
+	return !ok || time.Now().Before(returnAt)
+}
+
+// Expire turns the away mode off once we are back.
+func (a *Away) Expire() {
+	if a.Enabled.Get() && !a.IsActive() {
+		L.Info("Back from being away.", "return", a.Return.Get())
+		a.Enabled.Set(false)
+		a.Return.Set("")
+	}
+}
+
+// PreconditionLead is how long the room needs to get back within [min, max] at the learned rates.
+func PreconditionLead(hvac *Hvac, min float64, max float64) time.Duration {
+	current, err := hvac.AutoPilot.Sensors.Air.Get()
+	if err != nil {
+		return 0
+	}
+	hours := 0.0
+	if current < min && hvac.Thermal.WarmUp > 0 {
+		hours = (min - current) / hvac.Thermal.WarmUp
+	} else if current > max && hvac.Thermal.CoolDown > 0 {
+		hours = (current - max) / hvac.Thermal.CoolDown
+	}
+	return time.Duration(hours * float64(time.Hour))
+}
+
+func (a *Away) Adjust(hvac *Hvac, min float64, max float64) (float64, float64) {
+	if !a.IsActive() {
+		return min, max
+	}
+	if returnAt, ok := a.ReturnAt(); ok && time.Now().Add(PreconditionLead(hvac, min, max)).After(returnAt) {
+		return min, max // Time to get the room ready for our return.
+	}
+	return a.FrostProtection, a.HeatProtection
+}
+
+func (a *Away) PublishDiscovery() {
+	device := discovery.Device{Identifiers: "air3", Name: "air3", Model: "air3", Manufacturer: "Dorian"}
+	discovery.Publish(a.mqtt, "away", discovery.Switch{
+		Entity:       discovery.Entity{Name: "Away", UniqueID: "air3_away", Icon: "mdi:airplane", AvailabilityTopic: mqtt.AvailabilityTopic, Device: device},
+		CommandTopic: "air3/away/command",
+		StateTopic:   "air3/away/state",
+		PayloadOn:    "ON",
+		PayloadOff:   "OFF",
+	})
+	discovery.Publish(a.mqtt, "away_return", discovery.Text{
+		Entity:       discovery.Entity{Name: "Return from away", UniqueID: "air3_away_return", Icon: "mdi:home-clock", AvailabilityTopic: mqtt.AvailabilityTopic, Device: device},
+		CommandTopic: "air3/away/return/command",
+		StateTopic:   "air3/away/return/state",
+	})
+}
+
+func (a *Away) Ping() {
+	a.Enabled.Set(a.Enabled.Get())
+	a.Return.Set(a.Return.Get())
+}
+
+func NewAway(mqttClient paho.Client, frostProtection float64, heatProtection float64) *Away {
+	a := Away{
+		Enabled: mqtt.NewPersistedControlledValue(
+			mqttClient,
+			"air3/away/command",
+			"air3/away/state",
+			func(payload []byte) (bool, error) {
+				switch string(payload) {
+				case "ON":
+					return true, nil
+				case "OFF":
+					return false, nil
+				default:
+					return false, fmt.Errorf("invalid command: %v", payload)
+				}
+			},
+			func(value bool) string {
+				if value {
+					return "ON"
+				}
+				return "OFF"
+			},
+			false,
+		),
+		FrostProtection: frostProtection,
+		HeatProtection:  heatProtection,
+		mqtt:            mqttClient,
+	}
+	a.Return = mqtt.NewPersistedControlledValue(
+		mqttClient,
+		"air3/away/return/command",
+		"air3/away/return/state",
+		func(payload []byte) (string, error) {
+			value := string(payload)
+			if value == "" {
+				return value, nil
+			}
+			for _, layout := range returnLayouts {
+				if _, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+					return value, nil
+				}
+			}
+			return "", fmt.Errorf("invalid return time: %v", value)
+		},
+		func(value string) string {
+			return value
+		},
+		"",
+	)
+	a.PublishDiscovery()
+	return &a
+}
@@ -0,0 +1,39 @@
+package models
+
+import (
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+
+	"github.com/nanassito/air/pkg/discovery"
+	"github.com/nanassito/air/pkg/utils"
+)
+
+// Boost temporarily runs the unit at full power, bypassing the autopilot strategy.
+type Boost struct {
+	Requested bool
+	Until     time.Time
+}
+
+func (b *Boost) IsActive() bool {
+	return !b.Until.IsZero()
+}
+
+func (hvac *Hvac) boostCommandTopic() string {
+	return "air3/" + hvac.Name + "/boost/command"
+}
+
+func (hvac *Hvac) subscribeBoost() {
+	hvac.mqtt.Subscribe(hvac.boostCommandTopic(), 0, func(c paho.Client, m paho.Message) {
+		L.Debug("Received", "topic", m.Topic(), "payload", m.Payload())
+		utils.MainLoop.Do(func() { hvac.Boost.Requested = true })
+	})
+}
+
+func (hvac *Hvac) publishBoostDiscovery() {
+	discovery.Publish(hvac.mqtt, hvac.Name+"_boost", discovery.Button{
+		Entity:       hvac.DiscoveryEntity("Boost", "boost", "mdi:rocket-launch"),
+		CommandTopic: hvac.boostCommandTopic(),
+		PayloadPress: "PRESS",
+	})
+}
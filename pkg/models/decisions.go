@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+
+	"github.com/nanassito/air/pkg/events"
+)
+
+// How many decisions are kept around for the dashboard.
+const recentDecisions = 20
+
+type Decision struct {
+	Time     time.Time `json:"time"`
+	Decision string    `json:"decision"`
+}
+
+// Decide records what the autopilot decided for the hvac.
+func (hvac *Hvac) Decide(decision string) {
+	hvac.LastDecision = decision
+	hvac.Decisions = append(hvac.Decisions, Decision{Time: time.Now(), Decision: decision})
+	if len(hvac.Decisions) > recentDecisions {
+		hvac.Decisions = hvac.Decisions[len(hvac.Decisions)-recentDecisions:]
+	}
+	events.Default.Publish(events.Event{Type: events.TypeDecision, Unit: hvac.Name, Value: decision})
+}
@@ -0,0 +1,98 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/golang-collections/collections/set"
+
+	"github.com/nanassito/air/pkg/discovery"
+)
+
+type diagnostics struct {
+	DecisionScore   float64 `json:"decision_score"`
+	SensorTempTrend string  `json:"sensor_temp_trend"`
+	UnitTempRange   float64 `json:"unit_temp_range"`
+	// Null, shown as unknown in Home Assistant, when we haven't seen the mode change.
+	ModeUnchangedFor   *float64 `json:"mode_unchanged_for"`
+	UsableModes        []string `json:"usable_modes"`
+	LastDecision       string   `json:"last_decision"`
+	CommandUnconfirmed bool     `json:"command_unconfirmed"`
+}
+
+func (hvac *Hvac) diagnosticsTopic() string {
+	return "air3/" + hvac.Name + "/diagnostics"
+}
+
+// IsAcknowledged reports whether the unit confirmed all the latest commands.
+func (hvac *Hvac) IsAcknowledged() bool {
+	return hvac.Mode.IsAcknowledged() && hvac.Fan.IsAcknowledged() && hvac.Temperature.IsAcknowledged()
+}
+
+func (hvac *Hvac) diagnosticSensor(name string, id string, icon string, key string) discovery.Sensor {
+	entity := hvac.DiscoveryEntity(name, id, icon)
+	entity.EntityCategory = "diagnostic"
+	return discovery.Sensor{
+		Entity:        entity,
+		StateTopic:    hvac.diagnosticsTopic(),
+		ValueTemplate: fmt.Sprintf("{{ value_json.%s }}", key),
+	}
+}
+
+func (hvac *Hvac) publishDiagnosticsDiscovery() {
+	score := hvac.diagnosticSensor("Decision score", "decision_score", "mdi:scale-balance", "decision_score")
+	score.StateClass = "measurement"
+	trend := hvac.diagnosticSensor("Temperature trend", "sensor_temp_trend", "mdi:trending-up", "sensor_temp_trend")
+	unitRange := hvac.diagnosticSensor("In-unit temperature range", "unit_temp_range", "mdi:thermometer-lines", "unit_temp_range")
+	unitRange.UnitOfMeasurement = "°C"
+	unitRange.StateClass = "measurement"
+	unchanged := hvac.diagnosticSensor("Mode unchanged for", "mode_unchanged_for", "mdi:timer-outline", "mode_unchanged_for")
+	unchanged.UnitOfMeasurement = "s"
+	unchanged.DeviceClass = "duration"
+	usable := hvac.diagnosticSensor("Usable modes", "usable_modes", "mdi:swap-horizontal", "usable_modes")
+	usable.ValueTemplate = "{{ value_json.usable_modes | join(', ') }}"
+	decision := hvac.diagnosticSensor("Last decision", "last_decision", "mdi:head-lightbulb", "last_decision")
+
+	for _, sensor := range []discovery.Sensor{score, trend, unitRange, unchanged, usable, decision} {
+		discovery.Publish(hvac.mqtt, sensor.UniqueID, sensor)
+	}
+
+	unconfirmed := hvac.DiscoveryEntity("Command not acknowledged", "command_unconfirmed", "mdi:alert")
+	unconfirmed.EntityCategory = "diagnostic"
+	discovery.Publish(hvac.mqtt, unconfirmed.UniqueID, discovery.BinarySensor{
+		Entity:        unconfirmed,
+		StateTopic:    hvac.diagnosticsTopic(),
+		ValueTemplate: "{{ 'ON' if value_json.command_unconfirmed else 'OFF' }}",
+		DeviceClass:   "problem",
+	})
+}
+
+// PublishDiagnostics shares what the autopilot sees and decided for this hvac with Home Assistant.
+func (hvac *Hvac) PublishDiagnostics(usableModes *set.Set) {
+	modes := make([]string, 0, usableModes.Len())
+	usableModes.Do(func(mode interface{}) {
+		modes = append(modes, mode.(string))
+	})
+	sort.Strings(modes)
+	var unchangedFor *float64
+	if changed, ok := hvac.Mode.LastChange(); ok {
+		seconds := time.Since(changed).Seconds()
+		unchangedFor = &seconds
+	}
+	payload, err := json.Marshal(diagnostics{
+		DecisionScore:      hvac.DecisionScore,
+		SensorTempTrend:    hvac.AutoPilot.Sensors.Air.GetTrend().String(),
+		UnitTempRange:      hvac.AutoPilot.Sensors.Unit.GetRange(),
+		ModeUnchangedFor:   unchangedFor,
+		UsableModes:        modes,
+		LastDecision:       hvac.LastDecision,
+		CommandUnconfirmed: !hvac.IsAcknowledged(),
+	})
+	if err != nil {
+		L.Error("Failed to build the diagnostics", "err", err, "hvac", hvac.Name)
+		return
+	}
+	hvac.mqtt.Publish(hvac.diagnosticsTopic(), 0, false, payload)
+}
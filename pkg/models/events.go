@@ -0,0 +1,32 @@
+package models
+
+import (
+	"github.com/nanassito/air/pkg/events"
+	"github.com/nanassito/air/pkg/mqtt"
+)
+
+func observe[T bool | string | float64](hvac *Hvac, name string, value *mqtt.ThirdPartyValue[T]) {
+	value.Observe(func(observation mqtt.Observation, v T) {
+		event := events.Event{Type: string(observation), Unit: hvac.Name, Name: name, Value: v}
+		if observation == mqtt.ObservedState && name == "mode" {
+			event.Type = events.TypeMode
+		}
+		events.Default.Publish(event)
+	})
+}
+
+// publishEvents streams what happens to the unit to the live subscribers.
+func (hvac *Hvac) publishEvents() {
+	observe(hvac, "mode", hvac.Mode)
+	observe(hvac, "fan", hvac.Fan)
+	observe(hvac, "target_temp", hvac.Temperature)
+	for name, sensor := range map[string]*mqtt.TemperatureSensor{
+		"sensor_temp": hvac.AutoPilot.Sensors.Air,
+		"unit_temp":   hvac.AutoPilot.Sensors.Unit,
+	} {
+		name := name
+		sensor.Observe(func(temp float64) {
+			events.Default.Publish(events.Event{Type: events.TypeSensor, Unit: hvac.Name, Name: name, Value: temp})
+		})
+	}
+}
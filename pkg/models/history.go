@@ -0,0 +1,90 @@
+package models
+
+import (
+	"sort"
+	"time"
+
+	"github.com/nanassito/air/pkg/mqtt"
+	"github.com/nanassito/air/pkg/tsdb"
+)
+
+// HistoryColumn is a time series of a unit and the series it is stored under.
+type HistoryColumn struct {
+	Name    string
+	Series  string
+	Numeric bool
+}
+
+// HistoryColumns lists what is recorded for a unit, it doesn't need the unit to be running.
+func HistoryColumns(name string, sensorTopic string) []HistoryColumn {
+	topics := defaultTopics(name)
+	return []HistoryColumn{
+		{"sensor_temp", sensorTopic, true},
+		{"unit_temp", topics.unitTemp, true},
+		{"min_temp", topics.minTempState, true},
+		{"max_temp", topics.maxTempState, true},
+		{"mode", topics.modeState, false},
+		{"fan", topics.fanModeState, false},
+		{"target_temp", topics.targetTempState, true},
+		{"decision_score", topics.decisionScore, true},
+	}
+}
+
+// RecordDecisionScore persists the decision score in the history, when it changed.
+func (hvac *Hvac) RecordDecisionScore() {
+	if hvac.recordedScore != nil && *hvac.recordedScore == hvac.DecisionScore {
+		return
+	}
+	score := hvac.DecisionScore
+	hvac.recordedScore = &score
+	mqtt.Record(defaultTopics(hvac.Name).decisionScore, score)
+}
+
+func (hvac *Hvac) HistoryColumns() []HistoryColumn {
+	return HistoryColumns(hvac.Name, hvac.sensorTopic)
+}
+
+// HistoryRow is the state of a unit right after one of its columns changed.
+type HistoryRow struct {
+	Time   time.Time
+	Values map[string]string
+}
+
+// HistoryRows merges the columns into one row per change within [from, to], carrying the other columns forward.
+// Values from the day before from are used to know the state at the start of the range.
+func HistoryRows(store *tsdb.Store, columns []HistoryColumn, from time.Time, to time.Time) ([]HistoryRow, error) {
+	type change struct {
+		column string
+		point  tsdb.Point
+	}
+	changes := make([]change, 0)
+	for _, column := range columns {
+		points, err := store.Query(column.Series, from.Add(-24*time.Hour), to)
+		if err != nil {
+			return nil, err
+		}
+		for _, point := range points {
+			changes = append(changes, change{column.Name, point})
+		}
+	}
+	sort.SliceStable(changes, func(i, j int) bool { return changes[i].point.Time.Before(changes[j].point.Time) })
+
+	rows := make([]HistoryRow, 0)
+	state := map[string]string{}
+	for _, c := range changes {
+		state[c.column] = c.point.Value
+		if c.point.Time.Before(from) {
+			continue
+		}
+		values := make(map[string]string, len(state))
+		for column, value := range state {
+			values[column] = value
+		}
+		if n := len(rows); n > 0 && rows[n-1].Time.Equal(c.point.Time) {
+			rows[n-1].Values = values
+		} else {
+			rows = append(rows, HistoryRow{Time: c.point.Time, Values: values})
+		}
+	}
+	return rows, nil
+}
@@ -1,21 +1,34 @@
 package models
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 
 	paho "github.com/eclipse/paho.mqtt.golang"
-	"github.com/golang-collections/collections/set"
 
+	"github.com/nanassito/air/pkg/discovery"
 	"github.com/nanassito/air/pkg/mqtt"
+	"github.com/nanassito/air/pkg/scheduler"
 	"github.com/nanassito/air/pkg/utils"
 )
 
+const DefaultStrategy = "score"
+
+// strategies are the names a unit can select its strategy from.
+var strategies = map[string]bool{DefaultStrategy: true}
+
+// RegisterStrategyName lets the units select a strategy, the strategies themselves live in the logic package.
+func RegisterStrategyName(name string) {
+	strategies[name] = true
+}
+
 var (
 	ErrBadPayload = errors.New("invalid mqtt payload")
-	L             = utils.Logger
+	L             = utils.NewLogger("models")
 	fanSpeeds     = map[string]string{
 		"AUTO":   "AUTO",
 		"LOW":    "LOW",
@@ -33,32 +46,75 @@ var (
 type sensors struct {
 	Air  *mqtt.TemperatureSensor
 	Unit *mqtt.TemperatureSensor
+	// Optional, shared by every unit.
+	Outdoor *mqtt.TemperatureSensor
 }
 
 type autoPilot struct {
-	Enabled *mqtt.ControlledValue[bool]
-	MinTemp *mqtt.ControlledValue[float64]
-	MaxTemp *mqtt.ControlledValue[float64]
-	Sensors *sensors
+	Enabled  *mqtt.ControlledValue[bool]
+	MinTemp  *mqtt.ControlledValue[float64]
+	MaxTemp  *mqtt.ControlledValue[float64]
+	Strategy *mqtt.ControlledValue[string]
+	Tuning   *tuning
+	Sensors  *sensors
 }
 
 type Pump struct {
+	Name  string
 	Units []*Hvac
+	// Names of the units whose demand takes precedence when deciding between heating and cooling.
+	Priority []string
+	// Minimum time the pump keeps running in a mode before switching to the opposite one.
+	MinModeHold time.Duration
+	Arbitration Arbitration
+	Protection  CompressorProtection
+	Compressor  Compressor
+	// Minimum time between two units of the pump being turned on, to limit the inrush.
+	StartInterval time.Duration
+	LastUnitStart time.Time
+	// Optional meter on the outdoor unit.
+	PowerMeter *mqtt.PowerMeter
+	Runtime    Runtime
 }
 
-func (pump *Pump) GetUsableModes() *set.Set {
-	usableModes := set.New()
-	for _, mode := range modes {
-		usableModes.Insert(mode)
-	}
-	for _, hvac := range pump.Units {
-		usableModes = usableModes.Intersection(set.New("OFF", hvac.Mode.Get()))
+// CompressorProtection limits how often the outdoor compressor, shared by all the units, is cycled.
+type CompressorProtection struct {
+	MinOn            time.Duration
+	MinOff           time.Duration
+	MaxStartsPerHour int // 0 means unlimited
+}
+
+// Compressor tracks whether any unit of the pump is running.
+type Compressor struct {
+	Running bool        `json:"running"`
+	Since   time.Time   `json:"since"`
+	Starts  []time.Time `json:"starts"`
+}
+
+func (c *Compressor) StartsWithin(window time.Duration) int {
+	count := 0
+	for _, start := range c.Starts {
+		if time.Since(start) <= window {
+			count++
+		}
 	}
-	if usableModes.Has("OFF") && usableModes.Len() == 1 {
-		return set.New("OFF", "HEAT", "COOL", "FAN_ONLY")
+	return count
+}
+
+// Arbitration records the last decision about which mode the whole pump runs in.
+type Arbitration struct {
+	Mode   string
+	Since  time.Time
+	Reason string
+}
+
+func (pump *Pump) IsPriority(hvac *Hvac) bool {
+	for _, name := range pump.Priority {
+		if name == hvac.Name {
+			return true
+		}
 	}
-	L.Info("", "usableModes", usableModes)
-	return usableModes
+	return false
 }
 
 type Hvac struct {
@@ -68,6 +124,21 @@ type Hvac struct {
 	Fan           *mqtt.ThirdPartyValue[string]
 	Temperature   *mqtt.ThirdPartyValue[float64]
 	DecisionScore float64
+	LastDecision  string
+	Decisions     []Decision
+	Boost         Boost
+	Window        Window
+	Occupancy     Occupancy
+	Runtime       Runtime
+	Shed          Shed
+	Adjusters     []SetpointAdjuster
+	Thermal       ThermalModel
+	// Follow-up actions, cancelled whenever the autopilot changes the mode.
+	Actions     *scheduler.Scheduler
+	mqtt        paho.Client
+	sensorTopic string
+	// Last decision score persisted in the history, only changes are.
+	recordedScore *float64
 }
 
 func (hvac *Hvac) Log() {
@@ -83,27 +154,60 @@ func (hvac *Hvac) Log() {
 		"UnitTempRange", hvac.AutoPilot.Sensors.Unit.GetRange(),
 		"SensorTempTrend", hvac.AutoPilot.Sensors.Air.GetTrend(),
 		"SensorTemp", sensorTemp,
+		"autopilot.strategy", hvac.AutoPilot.Strategy.Get(),
 		"DecisionScore", hvac.DecisionScore,
 	)
 }
 
-func (hvac *Hvac) DecreaseFanSpeed() {
+func (hvac *Hvac) actionTopic() string {
+	return "air3/" + hvac.Name + "/action"
+}
+
+// Action tells what the unit is actually doing, in Home Assistant's hvac_action terms.
+// A running unit works while the room hasn't reached the target temperature or the unit is still warming up
+// (cooling down), and is most likely idling otherwise.
+func (hvac *Hvac) Action() string {
+	room, err := hvac.AutoPilot.Sensors.Air.Get()
+	trend := hvac.AutoPilot.Sensors.Unit.GetTrend()
+	switch hvac.Mode.Get() {
+	case "HEAT":
+		if err == nil && room >= hvac.Temperature.Get() && trend != mqtt.TrendWarmingUp {
+			return "idle"
+		}
+		return "heating"
+	case "COOL":
+		if err == nil && room <= hvac.Temperature.Get() && trend != mqtt.TrendCoolingDown {
+			return "idle"
+		}
+		return "cooling"
+	case "FAN_ONLY":
+		return "fan"
+	default:
+		return "off"
+	}
+}
+
+func (hvac *Hvac) PublishAction() {
+	hvac.mqtt.Publish(hvac.actionTopic(), 0, false, hvac.Action())
+}
+
+func (hvac *Hvac) DecreaseFanSpeed(ctx context.Context) {
 	switch hvac.Fan.Get() {
 	case "MEDIUM":
-		hvac.Fan.Set("AUTO")
+		hvac.SetFan(ctx, "AUTO")
 	case "HIGH":
-		hvac.Fan.Set("MEDIUM")
+		hvac.SetFan(ctx, "MEDIUM")
 	}
 }
 
-func (hvac *Hvac) IncreaseFanSpeed() {
+func (hvac *Hvac) IncreaseFanSpeed(ctx context.Context) {
 	switch hvac.Fan.Get() {
 	case "AUTO":
-		hvac.Fan.Set("MEDIUM")
+		hvac.SetFan(ctx, "MEDIUM")
 	case "LOW":
-		hvac.Fan.Set("MEDIUM")
+		hvac.SetFan(ctx, "MEDIUM")
 	case "MEDIUM":
-		hvac.Fan.Set("HIGH")
+		hvac.SetFan(ctx, "HIGH")
 	}
 }
 
@@ -111,19 +215,103 @@ func (hvac *Hvac) Ping() {
 	hvac.AutoPilot.Enabled.Set(hvac.AutoPilot.Enabled.Get())
 	hvac.AutoPilot.MinTemp.Set(hvac.AutoPilot.MinTemp.Get())
 	hvac.AutoPilot.MaxTemp.Set(hvac.AutoPilot.MaxTemp.Get())
+	hvac.AutoPilot.Strategy.Set(hvac.AutoPilot.Strategy.Get())
+	for _, p := range hvac.AutoPilot.Tuning.all() {
+		p.Set(p.Get())
+	}
+}
+
+// DiscoveryEntity returns the attributes shared by every entity of the hvac in Home Assistant.
+func (hvac *Hvac) DiscoveryEntity(name string, id string, icon string) discovery.Entity {
+	return discovery.Entity{
+		Name:              name,
+		UniqueID:          hvac.Name + "_" + id,
+		Icon:              icon,
+		AvailabilityTopic: mqtt.AvailabilityTopic,
+		Device: discovery.Device{
+			Identifiers:  hvac.Name,
+			Name:         hvac.Name,
+			Model:        "air3",
+			Manufacturer: "Dorian",
+		},
+	}
+}
+
+// PublishDiscovery (re-)declares the hvac to Home Assistant.
+func (hvac *Hvac) PublishDiscovery() {
+	topics := defaultTopics(hvac.Name)
+	// TODO:
+	// Use Mode for the autopilot-enabled, then use an icon to indicate which hvac this is about.
+	discovery.Publish(hvac.mqtt, hvac.Name, discovery.Climate{
+		Entity:                      hvac.DiscoveryEntity("Thermostat", "thermostat", "mdi:robot"),
+		MinTemp:                     17,
+		MaxTemp:                     33,
+		Precision:                   0.5,
+		TempStep:                    0.5,
+		TemperatureUnit:             "C",
+		TemperatureHighCommandTopic: topics.maxTempCommand,
+		TemperatureHighStateTopic:   topics.maxTempState,
+		TemperatureLowCommandTopic:  topics.minTempCommand,
+		TemperatureLowStateTopic:    topics.minTempState,
+		CurrentTemperatureTopic:     hvac.sensorTopic,
+		CurrentTemperatureTemplate:  "{{ value_json.temperature }}",
+		ActionTopic:                 hvac.actionTopic(),
+		ModeCommandTopic:            topics.enabledCommand,
+		ModeStateTopic:              topics.enabledState,
+		Modes:                       []string{"off", "auto"},
+		FanModeCommandTopic:         topics.fanModeCommand,
+		FanModeStateTopic:           topics.fanModeState,
+		PresetModes:                 []string{"sleep", "eco"},
+		PresetModeCommandTopic:      topics.presetCommand,
+		PresetModeStateTopic:        topics.presetState,
+	})
+	hvac.publishDiagnosticsDiscovery()
+	hvac.publishTuningDiscovery()
+	hvac.publishBoostDiscovery()
+	hvac.publishWindowDiscovery()
+	hvac.publishRuntimeDiscovery()
+	hvac.publishThermalDiscovery()
+}
+
+// hvacTopics are the mqtt topics of a unit, shared by the hvac and what reads its history without running it.
+type hvacTopics struct {
+	enabledCommand, enabledState       string
+	fanModeCommand, fanModeState       string
+	maxTempCommand, maxTempState       string
+	minTempCommand, minTempState       string
+	presetCommand, presetState         string
+	strategyCommand, strategyState     string
+	modeCommand, modeState             string
+	targetTempCommand, targetTempState string
+	unitTemp                           string
+	decisionScore                      string
+}
+
+func defaultTopics(name string) hvacTopics {
+	return hvacTopics{
+		enabledCommand:    "air3/" + name + "/autopilot/mode/command",
+		enabledState:      "air3/" + name + "/autopilot/mode/state",
+		fanModeCommand:    "esphome/" + name + "/fan_mode_command",
+		fanModeState:      "esphome/" + name + "/fan_mode_state",
+		maxTempCommand:    "air3/" + name + "/autopilot/maxTemp/command",
+		maxTempState:      "air3/" + name + "/autopilot/maxTemp/state",
+		minTempCommand:    "air3/" + name + "/autopilot/minTemp/command",
+		minTempState:      "air3/" + name + "/autopilot/minTemp/state",
+		presetCommand:     "air3/" + name + "/preset/command",
+		presetState:       "air3/" + name + "/preset/state",
+		strategyCommand:   "air3/" + name + "/autopilot/strategy/command",
+		strategyState:     "air3/" + name + "/autopilot/strategy/state",
+		modeCommand:       "esphome/" + name + "/mode_command",
+		modeState:         "esphome/" + name + "/mode_state",
+		targetTempCommand: "esphome/" + name + "/target_temperature_command",
+		targetTempState:   "esphome/" + name + "/target_temperature_low_state",
+		unitTemp:          "esphome/" + name + "/current_temperature_state",
+		decisionScore:     "air3/" + name + "/decision_score",
+	}
 }
 
 func NewHvacWithDefaultTopics(mqttClient paho.Client, name string, temperatureSensorTopic string) *Hvac {
-	enabled_command := "air3/" + name + "/autopilot/mode/command"
-	enabled_state := "air3/" + name + "/autopilot/mode/state"
-	fan_mode_command := "esphome/" + name + "/fan_mode_command"
-	fan_mode_state := "esphome/" + name + "/fan_mode_state"
-	maxTempCommand := "air3/" + name + "/autopilot/maxTemp/command"
-	maxTempState := "air3/" + name + "/autopilot/maxTemp/state"
-	minTempCommand := "air3/" + name + "/autopilot/minTemp/command"
-	minTempState := "air3/" + name + "/autopilot/minTemp/state"
-	presetCommandtopic := "air3/" + name + "/preset/command"
-	presetStatetopic := "air3/" + name + "/preset/state"
+	topics := defaultTopics(name)
 	sleepMaxTemp := 23.0
 	ecoMaxTemp := 33.0
 	hvac := Hvac{
@@ -131,8 +319,8 @@ func NewHvacWithDefaultTopics(mqttClient paho.Client, name string, temperatureSe
 		AutoPilot: &autoPilot{
 			Enabled: mqtt.NewControlledValue(
 				mqttClient,
-				enabled_command,
-				enabled_state,
+				topics.enabledCommand,
+				topics.enabledState,
 				func(payload []byte) (bool, error) {
 					switch string(payload) {
 					case "off":
@@ -153,8 +341,8 @@ func NewHvacWithDefaultTopics(mqttClient paho.Client, name string, temperatureSe
 			),
 			MinTemp: mqtt.NewControlledValue(
 				mqttClient,
-				minTempCommand,
-				minTempState,
+				topics.minTempCommand,
+				topics.minTempState,
 				func(payload []byte) (float64, error) {
 					return strconv.ParseFloat(string(payload), 64)
 				},
@@ -164,22 +352,22 @@ func NewHvacWithDefaultTopics(mqttClient paho.Client, name string, temperatureSe
 			),
 			MaxTemp: mqtt.NewControlledValue(
 				mqttClient,
-				maxTempCommand,
-				maxTempState,
+				topics.maxTempCommand,
+				topics.maxTempState,
 				func(payload []byte) (float64, error) {
 					temp, err := strconv.ParseFloat(string(payload), 64)
 					if temp <= 22 {
-						L.Warn("Invalid max temp", "temp", temp, "topic", maxTempCommand)
+						L.Warn("Invalid max temp", "temp", temp, "topic", topics.maxTempCommand)
 						return 22, fmt.Errorf("invalid max temp: %v", temp)
 					}
 					if err == nil {
 						switch int64(temp * 2) { // *2 to get rid of the floating point for .5°C
 						case int64(sleepMaxTemp * 2):
-							mqttClient.Publish(presetStatetopic, 0, false, "sleep")
+							mqttClient.Publish(topics.presetState, 0, false, "sleep")
 						case int64(ecoMaxTemp * 2):
-							mqttClient.Publish(presetStatetopic, 0, false, "eco")
+							mqttClient.Publish(topics.presetState, 0, false, "eco")
 						default:
-							mqttClient.Publish(presetStatetopic, 0, false, "none")
+							mqttClient.Publish(topics.presetState, 0, false, "none")
 						}
 					}
 					return temp, err
@@ -188,6 +376,21 @@ func NewHvacWithDefaultTopics(mqttClient paho.Client, name string, temperatureSe
 					return strconv.FormatFloat(value, 'f', 1, 64)
 				},
 			),
+			Strategy: mqtt.NewControlledValue(
+				mqttClient,
+				topics.strategyCommand,
+				topics.strategyState,
+				func(payload []byte) (string, error) {
+					if !strategies[string(payload)] {
+						return "", fmt.Errorf("unknown strategy: %q", payload)
+					}
+					return string(payload), nil
+				},
+				func(value string) string {
+					return value
+				},
+			),
+			Tuning: newTuning(mqttClient, name),
 			Sensors: &sensors{
 				Air: mqtt.NewJsonTemperatureSensor(
 					mqttClient,
@@ -195,14 +398,14 @@ func NewHvacWithDefaultTopics(mqttClient paho.Client, name string, temperatureSe
 				),
 				Unit: mqtt.NewRawTemperatureSensor(
 					mqttClient,
-					"esphome/"+name+"/current_temperature_state",
+					topics.unitTemp,
 				),
 			},
 		},
 		Mode: mqtt.NewThirdPartyValue(
 			mqttClient,
-			"esphome/"+name+"/mode_command",
-			"esphome/"+name+"/mode_state",
+			topics.modeCommand,
+			topics.modeState,
 			func(payload []byte) (string, error) {
 				mode, ok := modes[strings.ToUpper(string(payload))]
 				if ok {
@@ -217,8 +420,8 @@ func NewHvacWithDefaultTopics(mqttClient paho.Client, name string, temperatureSe
 		),
 		Fan: mqtt.NewThirdPartyValue(
 			mqttClient,
-			fan_mode_command,
-			fan_mode_state,
+			topics.fanModeCommand,
+			topics.fanModeState,
 			func(payload []byte) (string, error) {
 				speed, ok := fanSpeeds[strings.ToUpper(string(payload))]
 				if ok {
@@ -233,8 +436,8 @@ func NewHvacWithDefaultTopics(mqttClient paho.Client, name string, temperatureSe
 		),
 		Temperature: mqtt.NewThirdPartyValue(
 			mqttClient,
-			"esphome/"+name+"/target_temperature_command",
-			"esphome/"+name+"/target_temperature_low_state",
+			topics.targetTempCommand,
+			topics.targetTempState,
 			func(payload []byte) (float64, error) {
 				return strconv.ParseFloat(string(payload), 64)
 			},
@@ -243,63 +446,36 @@ func NewHvacWithDefaultTopics(mqttClient paho.Client, name string, temperatureSe
 			},
 		),
 		DecisionScore: 0,
+		Thermal:       newThermalModel(),
+		Actions:       scheduler.New(scheduler.RealClock, utils.MainLoop.Do),
+		mqtt:          mqttClient,
+		sensorTopic:   temperatureSensorTopic,
 	}
 
-	mqttClient.Subscribe(presetCommandtopic, 0, func(c paho.Client, m paho.Message) {
-		L.Info("Received", "topic", m.Topic(), "payload", m.Payload())
+	mqttClient.Subscribe(topics.presetCommand, 0, func(c paho.Client, m paho.Message) {
+		L.Debug("Received", "topic", m.Topic(), "payload", m.Payload())
 		switch string(m.Payload()) {
 		case "sleep":
-			mqttClient.Publish(presetStatetopic, 0, false, "sleep")
-			hvac.AutoPilot.MaxTemp.Set(sleepMaxTemp)
+			mqttClient.Publish(topics.presetState, 0, false, "sleep")
+			utils.MainLoop.Do(func() { hvac.AutoPilot.MaxTemp.Set(sleepMaxTemp) })
 		case "eco":
-			mqttClient.Publish(presetStatetopic, 0, false, "eco")
-			hvac.AutoPilot.MaxTemp.Set(ecoMaxTemp)
+			mqttClient.Publish(topics.presetState, 0, false, "eco")
+			utils.MainLoop.Do(func() { hvac.AutoPilot.MaxTemp.Set(ecoMaxTemp) })
 		default:
 			L.Warn("Invalid preset", "topic", m.Topic(), "payload", m.Payload())
 		}
 	})
 
-	// TODO:
-	// Use Mode for the autopilot-enabled, then use an icon to indicate which hvac this is about.
-	mqttClient.Publish(
-		"homeassistant/climate/air3/"+name+"/config",
-		0,
-		true,
-		`{
-			"name": "Thermostat",
-			"max_temp": 33,
-			"min_temp": 17,
-			"precision": 0.5,
-			"temp_step": 0.5,
-			"temperature_high_command_topic": "`+maxTempCommand+`",
-			"temperature_high_state_topic": "`+maxTempState+`",
-			"temperature_low_command_topic": "`+minTempCommand+`",
-			"temperature_low_state_topic": "`+minTempState+`",
-			"current_temperature_topic": "`+temperatureSensorTopic+`",
-			"current_temperature_template": "{{ value_json.temperature }}",
-			"temperature_unit": "C",
-			"unique_id": "`+name+`_thermostat",
-			"mode_command_topic": "`+enabled_command+`",
-			"mode_state_topic": "`+enabled_state+`",
-			"modes": ["off", "auto"],
-			"fan_mode_command_topic": "`+fan_mode_command+`",
-			"fan_mode_state_topic": "`+fan_mode_state+`",
-			"preset_modes": ["sleep", "eco"],
-			"preset_mode_command_topic": "`+presetCommandtopic+`",
-			"preset_mode_state_topic": "`+presetStatetopic+`",
-			"icon": "mdi:robot",
-			"device": {
-				"identifiers": "`+name+`",
-				"name": "`+name+`",
-				"model": "air3",
-				"manufacturer": "Dorian"
-			}
-		}`,
-	)
+	hvac.subscribeBoost()
+	hvac.restoreThermalModel()
+	hvac.publishEvents()
+	hvac.PublishDiscovery()
+
 	// If k8s shits the bed, everything will restart without a state.
 	// This will help start in a sensible configuration.
-	mqttClient.Publish(minTempCommand, 0, false, "19.0")
-	mqttClient.Publish(maxTempCommand, 0, false, "33.0")
-	mqttClient.Publish(enabled_command, 0, false, "auto")
+	mqttClient.Publish(topics.minTempCommand, 0, false, "19.0")
+	mqttClient.Publish(topics.maxTempCommand, 0, false, "33.0")
+	mqttClient.Publish(topics.enabledCommand, 0, false, "auto")
+	mqttClient.Publish(topics.strategyCommand, 0, false, DefaultStrategy)
 	return &hvac
 }
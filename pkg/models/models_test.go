@@ -1,12 +1,19 @@
 package models_test
 
 import (
+	"encoding/json"
 	"testing"
+	"time"
 
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/golang-collections/collections/set"
 	"github.com/matryer/is"
 
 	"github.com/nanassito/air/pkg/mocks"
 	"github.com/nanassito/air/pkg/models"
+	"github.com/nanassito/air/pkg/mqtt"
+	"github.com/nanassito/air/pkg/tsdb"
+	"github.com/nanassito/air/pkg/utils"
 )
 
 func TestHomeAssistantInterface(t *testing.T) {
@@ -36,3 +43,235 @@ func TestHomeAssistantInterface(t *testing.T) {
 		})
 	})
 }
+
+func TestDiagnostics(t *testing.T) {
+	is := is.New(t)
+	mqttClient := mocks.NewMockMqtt()
+	hvac := models.NewHvacWithDefaultTopics(mqttClient, "room", "nil")
+
+	diagnostics := map[string]any{}
+	mqttClient.Subscribe("air3/room/diagnostics", 0, func(c paho.Client, m paho.Message) {
+		is.NoErr(json.Unmarshal(m.Payload(), &diagnostics))
+	})
+	hvac.DecisionScore = 12
+	hvac.LastDecision = "Need more heat"
+	hvac.PublishDiagnostics(set.New("OFF", "HEAT"))
+
+	is.Equal(12.0, diagnostics["decision_score"])
+	is.Equal("Need more heat", diagnostics["last_decision"])
+	is.Equal([]any{"HEAT", "OFF"}, diagnostics["usable_modes"])
+	is.Equal(false, diagnostics["command_unconfirmed"])
+	is.Equal(nil, diagnostics["mode_unchanged_for"]) // We never saw the mode change.
+
+	unit := mocks.NewMockHvac(mqttClient, "room")
+	unit.SetMode("HEAT")
+	hvac.PublishDiagnostics(set.New("OFF", "HEAT"))
+	is.True(diagnostics["mode_unchanged_for"].(float64) < 1)
+}
+
+func TestAction(t *testing.T) {
+	is := is.New(t)
+	mqttClient := mocks.NewMockMqtt()
+	roomTemp := mocks.NewMockTemperatureSensor(mqttClient, "sensor")
+	hvac := models.NewHvacWithDefaultTopics(mqttClient, "room", roomTemp.Topic())
+	unit := mocks.NewMockHvac(mqttClient, "room")
+	is.Equal("off", hvac.Action())
+
+	unit.SetMode("HEAT")
+	mqttClient.Publish("esphome/room/target_temperature_command", 0, false, "22.0")
+	unit.ReportUnitTemperature(25)
+	roomTemp.Set(19)
+	is.Equal("heating", hvac.Action()) // The room is still converging.
+
+	roomTemp.Set(22.5)
+	is.Equal("idle", hvac.Action())
+
+	unit.ReportUnitTemperature(26)
+	is.Equal("heating", hvac.Action()) // The unit is still warming up.
+
+	unit.SetMode("COOL")
+	is.Equal("cooling", hvac.Action())
+}
+
+func TestAway(t *testing.T) {
+	is := is.New(t)
+	mqttClient := mocks.NewMockMqtt()
+	roomTemp := mocks.NewMockTemperatureSensor(mqttClient, "sensor")
+	hvac := models.NewHvacWithDefaultTopics(mqttClient, "room", roomTemp.Topic())
+	site := models.NewSite([]*models.Pump{{Units: []*models.Hvac{hvac}}}, models.NewTariff(mqttClient, "", 1), models.NewAway(mqttClient, 12, 30))
+	mocks.DesiredMinTemp(mqttClient, "room", 20)
+	mocks.DesiredMaxTemp(mqttClient, "room", 25)
+	roomTemp.Set(14)
+
+	min, max := hvac.DesiredRange()
+	is.Equal(20.0, min)
+	is.Equal(25.0, max)
+
+	t.Run("protection limits", func(t *testing.T) {
+		mqttClient.Publish("air3/away/command", 0, false, "ON")
+		mqttClient.Publish("air3/away/return/command", 0, false, time.Now().Add(24*time.Hour).Format(time.RFC3339))
+		utils.MainLoop.RunPending()
+		min, max := hvac.DesiredRange()
+		is.Equal(12.0, min)
+		is.Equal(30.0, max)
+	})
+
+	t.Run("preconditioning", func(t *testing.T) {
+		// The room needs 6h to warm up at 1°C/h.
+		mqttClient.Publish("air3/away/return/command", 0, false, time.Now().Add(5*time.Hour).Format(time.RFC3339))
+		utils.MainLoop.RunPending()
+		min, _ := hvac.DesiredRange()
+		is.Equal(20.0, min)
+	})
+
+	t.Run("expires", func(t *testing.T) {
+		mqttClient.Publish("air3/away/return/command", 0, false, time.Now().Add(-time.Minute).Format(time.RFC3339))
+		utils.MainLoop.RunPending()
+		site.Away.Expire()
+		is.Equal(false, site.Away.Enabled.Get())
+	})
+}
+
+func TestOccupancy(t *testing.T) {
+	is := is.New(t)
+	mqttClient := mocks.NewMockMqtt()
+	roomTemp := mocks.NewMockTemperatureSensor(mqttClient, "sensor")
+	hvac := models.NewHvacWithDefaultTopics(mqttClient, "room", roomTemp.Topic()).WithOccupancySensors("zigbee2mqtt/motion").WithPresence("home/person")
+	mocks.DesiredMinTemp(mqttClient, "room", 20)
+	mocks.DesiredMaxTemp(mqttClient, "room", 25)
+	mocks.Tuning(mqttClient, "room", "vacant_after", 0)
+
+	t.Run("unknown", func(t *testing.T) {
+		mqttClient.Publish("zigbee2mqtt/motion", 0, false, `{"occupancy": false}`)
+		is.True(!hvac.Occupancy.IsVacant(hvac))
+	})
+
+	t.Run("vacant", func(t *testing.T) {
+		mqttClient.Publish("home/person", 0, false, "not_home")
+		min, max := hvac.DesiredRange()
+		is.Equal(18.0, min)
+		is.Equal(27.0, max)
+	})
+
+	t.Run("presence", func(t *testing.T) {
+		mqttClient.Publish("home/person", 0, false, "home")
+		min, max := hvac.DesiredRange()
+		is.Equal(20.0, min)
+		is.Equal(25.0, max)
+	})
+}
+
+func TestTariff(t *testing.T) {
+	is := is.New(t)
+	mqttClient := mocks.NewMockMqtt()
+	roomTemp := mocks.NewMockTemperatureSensor(mqttClient, "sensor")
+	hvac := models.NewHvacWithDefaultTopics(mqttClient, "room", roomTemp.Topic())
+	tariff := models.NewTariff(mqttClient, "energy/tariff", 1)
+	site := models.NewSite([]*models.Pump{{Units: []*models.Hvac{hvac}}}, tariff, models.NewAway(mqttClient, 12, 30))
+	mocks.DesiredMinTemp(mqttClient, "room", 20)
+	mocks.DesiredMaxTemp(mqttClient, "room", 23)
+
+	t.Run("normal", func(t *testing.T) {
+		min, max := hvac.DesiredRange()
+		is.Equal(20.0, min)
+		is.Equal(23.0, max)
+	})
+
+	t.Run("cheap", func(t *testing.T) {
+		mqttClient.Publish("energy/tariff", 0, false, "cheap")
+		utils.MainLoop.RunPending()
+		min, max := hvac.DesiredRange()
+		is.Equal(21.0, min)
+		is.Equal(22.0, max)
+		is.Equal("cheap", site.Status().Tariff.Level)
+	})
+
+	t.Run("peak", func(t *testing.T) {
+		mqttClient.Publish("energy/tariff", 0, false, "peak")
+		utils.MainLoop.RunPending()
+		min, max := hvac.DesiredRange()
+		is.Equal(19.0, min)
+		is.Equal(24.0, max)
+	})
+
+	t.Run("periods", func(t *testing.T) {
+		now := time.Now()
+		offset := now.Sub(time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()))
+		tariff := models.NewTariff(mqttClient, "", 1, models.TariffPeriod{Start: offset - time.Minute, End: offset + time.Minute, Level: models.TariffPeak})
+		is.Equal(models.TariffPeak, tariff.Level())
+	})
+
+	t.Run("parse periods", func(t *testing.T) {
+		period, err := models.ParseTariffPeriod("cheap=22:00-06:30")
+		is.NoErr(err)
+		is.Equal(models.TariffPeriod{Start: 22 * time.Hour, End: 6*time.Hour + 30*time.Minute, Level: models.TariffCheap}, period)
+		_, err = models.ParseTariffPeriod("free=22:00-06:00")
+		is.True(err != nil)
+		_, err = models.ParseTariffPeriod("cheap=22h-6h")
+		is.True(err != nil)
+	})
+}
+
+func TestRuntime(t *testing.T) {
+	is := is.New(t)
+	runtime := models.Runtime{}
+	start := time.Date(2024, 1, 1, 20, 0, 0, 0, time.Local)
+
+	runtime.Record(start, "HEAT", "HIGH", 2000)
+	runtime.Record(start.Add(30*time.Minute), "HEAT", "LOW", 1000)
+	runtime.Record(start.Add(90*time.Minute), "OFF", "LOW", 0)
+	runtime.Record(start.Add(2*time.Hour), "COOL", "AUTO", 0)
+	status := runtime.Status()
+	is.Equal(1.5, status.HeatHours)
+	is.Equal(0.0, status.CoolHours)
+	is.Equal(0.5, status.FanHours["HIGH"])
+	is.Equal(1.0, status.FanHours["LOW"])
+	is.Equal(2.0, status.EnergyKwh)
+
+	// Totals reset at midnight.
+	runtime.Record(start.Add(5*time.Hour), "COOL", "AUTO", 0)
+	status = runtime.Status()
+	is.Equal(time.Date(2024, 1, 2, 0, 0, 0, 0, time.Local), status.Day)
+	is.Equal(1.0, status.CoolHours)
+	is.Equal(0.0, status.HeatHours)
+}
+
+func TestHistoryRows(t *testing.T) {
+	is := is.New(t)
+	store, err := tsdb.Open(t.TempDir(), 24*time.Hour)
+	is.NoErr(err)
+	defer store.Close()
+	columns := models.HistoryColumns("room", "sensor")
+	start := time.Now().Add(-time.Hour)
+
+	is.NoErr(store.Append("esphome/room/mode_state", start.Add(-time.Minute), "HEAT"))
+	is.NoErr(store.Append("sensor", start.Add(time.Minute), "19.5"))
+	is.NoErr(store.Append("esphome/room/mode_state", start.Add(2*time.Minute), "OFF"))
+
+	rows, err := models.HistoryRows(store, columns, start, time.Now())
+	is.NoErr(err)
+	is.Equal(2, len(rows))
+	is.Equal(map[string]string{"mode": "HEAT", "sensor_temp": "19.5"}, rows[0].Values) // Mode carried from before the range.
+	is.Equal(map[string]string{"mode": "OFF", "sensor_temp": "19.5"}, rows[1].Values)
+}
+
+func TestRecordDecisionScore(t *testing.T) {
+	is := is.New(t)
+	store, err := tsdb.Open(t.TempDir(), 24*time.Hour)
+	is.NoErr(err)
+	defer store.Close()
+	mqtt.History = store
+	defer func() { mqtt.History = nil }()
+	hvac := models.NewHvacWithDefaultTopics(mocks.NewMockMqtt(), "room", "sensor")
+
+	hvac.RecordDecisionScore()
+	hvac.RecordDecisionScore()
+	hvac.DecisionScore = 2
+	hvac.RecordDecisionScore()
+	hvac.RecordDecisionScore()
+
+	points, err := store.Query("air3/room/decision_score", time.Now().Add(-time.Hour), time.Now())
+	is.NoErr(err)
+	is.Equal(2, len(points))
+	is.Equal("2", points[1].Value)
+}
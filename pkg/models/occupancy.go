@@ -0,0 +1,70 @@
+package models
+
+import (
+	"time"
+
+	"github.com/nanassito/air/pkg/mqtt"
+)
+
+// Occupancy relaxes the setpoints of rooms nobody has been in for a while.
+type Occupancy struct {
+	Sensors []*mqtt.OccupancySensor
+}
+
+// WithOccupancySensors adds zigbee motion or presence sensors for the room.
+func (hvac *Hvac) WithOccupancySensors(topics ...string) *Hvac {
+	for _, topic := range topics {
+		hvac.addOccupancySensor(mqtt.NewJsonOccupancySensor(hvac.mqtt, topic))
+	}
+	return hvac
+}
+
+// WithPresence adds plain presence states for the room, such as a Home Assistant person forwarded over mqtt.
+func (hvac *Hvac) WithPresence(topics ...string) *Hvac {
+	for _, topic := range topics {
+		hvac.addOccupancySensor(mqtt.NewPresenceSensor(hvac.mqtt, topic))
+	}
+	return hvac
+}
+
+func (hvac *Hvac) addOccupancySensor(sensor *mqtt.OccupancySensor) {
+	if len(hvac.Occupancy.Sensors) == 0 {
+		hvac.Adjusters = append(hvac.Adjusters, &hvac.Occupancy)
+	}
+	hvac.Occupancy.Sensors = append(hvac.Occupancy.Sensors, sensor)
+}
+
+// LastSeen is the last time any sensor detected someone, zero if a sensor hasn't reported yet.
+func (o *Occupancy) LastSeen() time.Time {
+	lastSeen := time.Time{}
+	for _, sensor := range o.Sensors {
+		seen := sensor.LastSeen()
+		if seen.IsZero() {
+			return time.Time{}
+		}
+		if seen.After(lastSeen) {
+			lastSeen = seen
+		}
+	}
+	return lastSeen
+}
+
+// IsVacant is true once nobody has been seen for the configured delay. Rooms without sensors are never vacant.
+func (o *Occupancy) IsVacant(hvac *Hvac) bool {
+	for _, sensor := range o.Sensors {
+		if sensor.IsOccupied() {
+			return false
+		}
+	}
+	lastSeen := o.LastSeen()
+	return !lastSeen.IsZero() && time.Since(lastSeen) >= hvac.AutoPilot.Tuning.VacantAfter.Minutes()
+}
+
+// Adjust widens the comfort range while the room is vacant, presence brings it right back.
+func (o *Occupancy) Adjust(hvac *Hvac, min float64, max float64) (float64, float64) {
+	if !o.IsVacant(hvac) {
+		return min, max
+	}
+	relax := hvac.AutoPilot.Tuning.VacantRelax.Get()
+	return min - relax, max + relax
+}
@@ -0,0 +1,47 @@
+package models
+
+import (
+	"context"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+
+	"github.com/nanassito/air/pkg/mqtt"
+)
+
+// PowerLimit keeps the whole house under what the main breaker can take.
+type PowerLimit struct {
+	Meter *mqtt.PowerMeter
+	// Power (in W) above which units get shed.
+	Limit float64
+	// How far (in W) under the limit we need to be before giving units back.
+	Margin float64
+}
+
+// Shed is what the power limit took away from a unit, so it can be given back.
+type Shed struct {
+	Paused bool `json:"paused"`
+	// Fan speed before it was slowed down.
+	Fan string `json:"fan,omitempty"`
+	// Fastest fan speed allowed until the power is back under the limit.
+	Ceiling string `json:"fan_ceiling,omitempty"`
+}
+
+// From the least to the most power hungry.
+var fanRanks = map[string]int{"LOW": 0, "AUTO": 1, "MEDIUM": 2, "HIGH": 3}
+
+// SetFan changes the fan speed, capped while the power limit holds it down.
+func (hvac *Hvac) SetFan(ctx context.Context, fan string) {
+	if ceiling := hvac.Shed.Ceiling; ceiling != "" && fanRanks[fan] > fanRanks[ceiling] {
+		L.Info("Fan speed is capped to stay under the power limit", "hvac", hvac.Name, "wanted", fan, "ceiling", ceiling)
+		fan = ceiling
+	}
+	hvac.Fan.Set(ctx, fan)
+}
+
+func NewPowerLimit(mqttClient paho.Client, meterTopic string, limit float64, margin float64) *PowerLimit {
+	return &PowerLimit{
+		Meter:  mqtt.NewJsonPowerMeter(mqttClient, meterTopic),
+		Limit:  limit,
+		Margin: margin,
+	}
+}
@@ -0,0 +1,159 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+
+	"github.com/nanassito/air/pkg/discovery"
+	"github.com/nanassito/air/pkg/mqtt"
+)
+
+// Runtime accumulates how long a unit or pump ran in each mode and fan speed since midnight.
+type Runtime struct {
+	Day   time.Time
+	Modes map[string]time.Duration
+	Fans  map[string]time.Duration
+	// Energy drawn, in kWh, when there is a power meter.
+	Energy float64
+
+	lastAt    time.Time
+	lastMode  string
+	lastFan   string
+	lastPower float64
+}
+
+// Record credits the time elapsed since the previous sample to the state seen back then.
+func (r *Runtime) Record(now time.Time, mode string, fan string, power float64) {
+	if day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()); !day.Equal(r.Day) {
+		r.Day, r.Modes, r.Fans, r.Energy = day, map[string]time.Duration{}, map[string]time.Duration{}, 0
+		if !r.lastAt.IsZero() && r.lastAt.Before(day) {
+			r.lastAt = day
+		}
+	}
+	if !r.lastAt.IsZero() {
+		elapsed := now.Sub(r.lastAt)
+		if r.lastMode != "" && r.lastMode != "OFF" {
+			r.Modes[r.lastMode] += elapsed
+			if r.lastFan != "" {
+				r.Fans[r.lastFan] += elapsed
+			}
+		}
+		r.Energy += r.lastPower * elapsed.Hours() / 1000
+	}
+	r.lastAt, r.lastMode, r.lastFan, r.lastPower = now, mode, fan, power
+}
+
+type RuntimeStatus struct {
+	Day       time.Time          `json:"day"`
+	HeatHours float64            `json:"heat_hours"`
+	CoolHours float64            `json:"cool_hours"`
+	FanHours  map[string]float64 `json:"fan_hours"`
+	EnergyKwh float64            `json:"energy_kwh"`
+}
+
+func (r *Runtime) Status() RuntimeStatus {
+	fans := map[string]float64{}
+	for fan, d := range r.Fans {
+		fans[fan] = d.Hours()
+	}
+	return RuntimeStatus{
+		Day:       r.Day,
+		HeatHours: r.Modes["HEAT"].Hours(),
+		CoolHours: r.Modes["COOL"].Hours(),
+		FanHours:  fans,
+		EnergyKwh: r.Energy,
+	}
+}
+
+func (r *Runtime) publish(mqttClient paho.Client, topic string) {
+	payload, err := json.Marshal(r.Status())
+	if err != nil {
+		L.Error("Failed to build the runtime", "err", err, "topic", topic)
+		return
+	}
+	mqttClient.Publish(topic, 0, true, payload)
+}
+
+// runtimeSensors are daily totals, which Home Assistant's statistics handle as meters resetting at midnight.
+func runtimeSensors(entity func(name string, id string, icon string) discovery.Entity, topic string, fans []string, energy bool) []discovery.Sensor {
+	sensor := func(name string, id string, icon string, template string, unit string, deviceClass string) discovery.Sensor {
+		return discovery.Sensor{
+			Entity:            entity(name, id, icon),
+			StateTopic:        topic,
+			ValueTemplate:     template,
+			UnitOfMeasurement: unit,
+			DeviceClass:       deviceClass,
+			StateClass:        "total_increasing",
+		}
+	}
+	sensors := []discovery.Sensor{
+		sensor("Heating time today", "heat_runtime", "mdi:fire", "{{ value_json.heat_hours | round(2) }}", "h", "duration"),
+		sensor("Cooling time today", "cool_runtime", "mdi:snowflake", "{{ value_json.cool_hours | round(2) }}", "h", "duration"),
+	}
+	for _, fan := range fans {
+		sensors = append(sensors, sensor(
+			fmt.Sprintf("Fan %s time today", fan),
+			"fan_"+fan+"_runtime",
+			"mdi:fan",
+			fmt.Sprintf("{{ value_json.fan_hours.%s | default(0) | round(2) }}", fan),
+			"h",
+			"duration",
+		))
+	}
+	if energy {
+		sensors = append(sensors, sensor("Energy today", "energy", "mdi:lightning-bolt", "{{ value_json.energy_kwh | round(3) }}", "kWh", "energy"))
+	}
+	return sensors
+}
+
+func (hvac *Hvac) runtimeTopic() string {
+	return "air3/" + hvac.Name + "/runtime"
+}
+
+func (hvac *Hvac) PublishRuntime() {
+	hvac.Runtime.publish(hvac.mqtt, hvac.runtimeTopic())
+}
+
+func (hvac *Hvac) publishRuntimeDiscovery() {
+	fans := make([]string, 0, len(fanSpeeds))
+	for fan := range fanSpeeds {
+		fans = append(fans, fan)
+	}
+	sort.Strings(fans)
+	for _, sensor := range runtimeSensors(hvac.DiscoveryEntity, hvac.runtimeTopic(), fans, false) {
+		discovery.Publish(hvac.mqtt, sensor.UniqueID, sensor)
+	}
+}
+
+func (pump *Pump) runtimeTopic() string {
+	return "air3/pumps/" + pump.Name + "/runtime"
+}
+
+func (pump *Pump) discoveryEntity(name string, id string, icon string) discovery.Entity {
+	return discovery.Entity{
+		Name:              name,
+		UniqueID:          "pump_" + pump.Name + "_" + id,
+		Icon:              icon,
+		AvailabilityTopic: mqtt.AvailabilityTopic,
+		Device: discovery.Device{
+			Identifiers:  "air3_pump_" + pump.Name,
+			Name:         pump.Name + " pump",
+			Model:        "air3",
+			Manufacturer: "Dorian",
+		},
+	}
+}
+
+func (pump *Pump) PublishRuntime(mqttClient paho.Client) {
+	pump.Runtime.publish(mqttClient, pump.runtimeTopic())
+}
+
+func (pump *Pump) PublishRuntimeDiscovery(mqttClient paho.Client) {
+	for _, sensor := range runtimeSensors(pump.discoveryEntity, pump.runtimeTopic(), nil, pump.PowerMeter != nil) {
+		discovery.Publish(mqttClient, sensor.UniqueID, sensor)
+	}
+}
@@ -0,0 +1,25 @@
+package models
+
+// SetpointAdjuster shifts the comfort range the autopilot aims for, without touching the user's setpoints.
+type SetpointAdjuster interface {
+	Adjust(hvac *Hvac, min float64, max float64) (float64, float64)
+}
+
+// DesiredRange is the comfort range after applying every adjuster to the user's setpoints.
+func (hvac *Hvac) DesiredRange() (float64, float64) {
+	min, max := hvac.AutoPilot.MinTemp.Get(), hvac.AutoPilot.MaxTemp.Get()
+	for _, adjuster := range hvac.Adjusters {
+		min, max = adjuster.Adjust(hvac, min, max)
+	}
+	return min, max
+}
+
+func (hvac *Hvac) DesiredMin() float64 {
+	min, _ := hvac.DesiredRange()
+	return min
+}
+
+func (hvac *Hvac) DesiredMax() float64 {
+	_, max := hvac.DesiredRange()
+	return max
+}
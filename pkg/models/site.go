@@ -0,0 +1,45 @@
+package models
+
+import "github.com/nanassito/air/pkg/mqtt"
+
+// Site is the whole house: every pump and the settings shared by all the units.
+type Site struct {
+	Pumps  []*Pump
+	Tariff *Tariff
+	Away   *Away
+	// Optional, nil when there is no power meter.
+	PowerLimit *PowerLimit
+}
+
+// Unit finds a unit by name, nil if there is none.
+func (site *Site) Unit(name string) *Hvac {
+	for _, hvac := range site.Units() {
+		if hvac.Name == name {
+			return hvac
+		}
+	}
+	return nil
+}
+
+func (site *Site) Units() []*Hvac {
+	units := make([]*Hvac, 0)
+	for _, pump := range site.Pumps {
+		units = append(units, pump.Units...)
+	}
+	return units
+}
+
+// SetOutdoorSensor shares the outdoor temperature with every unit.
+func (site *Site) SetOutdoorSensor(sensor *mqtt.TemperatureSensor) {
+	for _, hvac := range site.Units() {
+		hvac.AutoPilot.Sensors.Outdoor = sensor
+	}
+}
+
+func NewSite(pumps []*Pump, tariff *Tariff, away *Away) *Site {
+	site := Site{Pumps: pumps, Tariff: tariff, Away: away}
+	for _, hvac := range site.Units() {
+		hvac.Adjusters = append(hvac.Adjusters, tariff, away)
+	}
+	return &site
+}
@@ -0,0 +1,131 @@
+package models
+
+import (
+	"time"
+
+	"github.com/nanassito/air/pkg/scheduler"
+)
+
+type HvacStatus struct {
+	Name              string              `json:"name"`
+	AutoPilotEnabled  bool                `json:"autopilot_enabled"`
+	AutoPilotStrategy string              `json:"autopilot_strategy"`
+	MinTemp           float64             `json:"min_temp"`
+	MaxTemp           float64             `json:"max_temp"`
+	DesiredMinTemp    float64             `json:"desired_min_temp"`
+	DesiredMaxTemp    float64             `json:"desired_max_temp"`
+	Thermal           ThermalModel        `json:"thermal_model"`
+	Mode              string              `json:"mode"`
+	Action            string              `json:"action"`
+	Fan               string              `json:"fan"`
+	TargetTemp        float64             `json:"target_temp"`
+	SensorTemp        float64             `json:"sensor_temp"`
+	SensorTempTrend   string              `json:"sensor_temp_trend"`
+	UnitTempRange     float64             `json:"unit_temp_range"`
+	DecisionScore     float64             `json:"decision_score"`
+	BoostUntil        time.Time           `json:"boost_until"`
+	WindowOpen        bool                `json:"window_open"`
+	Vacant            bool                `json:"vacant"`
+	Shed              Shed                `json:"shed"`
+	LastDecision      string              `json:"last_decision"`
+	RecentDecisions   []Decision          `json:"recent_decisions"`
+	Acknowledged      bool                `json:"acknowledged"`
+	PendingActions    []scheduler.Pending `json:"pending_actions"`
+	Runtime           RuntimeStatus       `json:"runtime"`
+}
+
+type PumpStatus struct {
+	Name       string        `json:"name"`
+	Mode       string        `json:"mode"`
+	ModeSince  time.Time     `json:"mode_since"`
+	ModeReason string        `json:"mode_reason"`
+	Compressor Compressor    `json:"compressor"`
+	LastStart  time.Time     `json:"last_unit_start"`
+	Units      []HvacStatus  `json:"units"`
+	Runtime    RuntimeStatus `json:"runtime"`
+}
+
+func (hvac *Hvac) Status() HvacStatus {
+	sensorTemp, _ := hvac.AutoPilot.Sensors.Air.Get()
+	desiredMin, desiredMax := hvac.DesiredRange()
+	return HvacStatus{
+		Name:              hvac.Name,
+		AutoPilotEnabled:  hvac.AutoPilot.Enabled.Get(),
+		AutoPilotStrategy: hvac.AutoPilot.Strategy.Get(),
+		MinTemp:           hvac.AutoPilot.MinTemp.Get(),
+		MaxTemp:           hvac.AutoPilot.MaxTemp.Get(),
+		DesiredMinTemp:    desiredMin,
+		DesiredMaxTemp:    desiredMax,
+		Thermal:           hvac.Thermal,
+		Mode:              hvac.Mode.Get(),
+		Action:            hvac.Action(),
+		Fan:               hvac.Fan.Get(),
+		TargetTemp:        hvac.Temperature.Get(),
+		SensorTemp:        sensorTemp,
+		SensorTempTrend:   hvac.AutoPilot.Sensors.Air.GetTrend().String(),
+		UnitTempRange:     hvac.AutoPilot.Sensors.Unit.GetRange(),
+		DecisionScore:     hvac.DecisionScore,
+		BoostUntil:        hvac.Boost.Until,
+		WindowOpen:        hvac.Window.Open,
+		Vacant:            hvac.Occupancy.IsVacant(hvac),
+		Shed:              hvac.Shed,
+		LastDecision:      hvac.LastDecision,
+		RecentDecisions:   hvac.Decisions,
+		Acknowledged:      hvac.IsAcknowledged(),
+		PendingActions:    hvac.Actions.Pending(),
+		Runtime:           hvac.Runtime.Status(),
+	}
+}
+
+func (pump *Pump) Status() PumpStatus {
+	units := make([]HvacStatus, 0, len(pump.Units))
+	for _, hvac := range pump.Units {
+		units = append(units, hvac.Status())
+	}
+	return PumpStatus{
+		Name:       pump.Name,
+		Mode:       pump.Arbitration.Mode,
+		ModeSince:  pump.Arbitration.Since,
+		ModeReason: pump.Arbitration.Reason,
+		Compressor: pump.Compressor,
+		LastStart:  pump.LastUnitStart,
+		Runtime:    pump.Runtime.Status(),
+		Units:      units,
+	}
+}
+
+type AwayStatus struct {
+	Enabled bool   `json:"enabled"`
+	Active  bool   `json:"active"`
+	Return  string `json:"return"`
+}
+
+type TariffStatus struct {
+	Level string  `json:"level"`
+	Band  float64 `json:"band"`
+}
+
+type SiteStatus struct {
+	Away   AwayStatus   `json:"away"`
+	Tariff TariffStatus `json:"tariff"`
+	Pumps  []PumpStatus `json:"pumps"`
+}
+
+func (site *Site) Status() SiteStatus {
+	pumps := make([]PumpStatus, 0, len(site.Pumps))
+	for _, pump := range site.Pumps {
+		pumps = append(pumps, pump.Status())
+	}
+	return SiteStatus{
+		Away: AwayStatus{
+			Enabled: site.Away.Enabled.Get(),
+			Active:  site.Away.IsActive(),
+			Return:  site.Away.Return.Get(),
+		},
+		Tariff: TariffStatus{
+			Level: site.Tariff.Level(),
+			Band:  site.Tariff.Band,
+		},
+		Pumps: pumps,
+	}
+}
@@ -0,0 +1,121 @@
+package models
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+
+	"github.com/nanassito/air/pkg/utils"
+)
+
+const (
+	TariffCheap  = "cheap"
+	TariffNormal = "normal"
+	TariffPeak   = "peak"
+)
+
+// A price feed we haven't heard from for that long is ignored in favor of the configured periods.
+const tariffFeedMaxAge = 2 * time.Hour
+
+// TariffPeriod is a time of day, relative to midnight, during which electricity is priced at Level.
+// Periods may wrap around midnight.
+type TariffPeriod struct {
+	Start time.Duration
+	End   time.Duration
+	Level string
+}
+
+// ParseTariffPeriod reads a period such as "cheap=22:00-06:00".
+func ParseTariffPeriod(value string) (TariffPeriod, error) {
+	level, hours, found := strings.Cut(value, "=")
+	start, end, found2 := strings.Cut(hours, "-")
+	if !found || !found2 {
+		return TariffPeriod{}, fmt.Errorf("expected level=HH:MM-HH:MM, got %q", value)
+	}
+	switch level {
+	case TariffCheap, TariffNormal, TariffPeak:
+	default:
+		return TariffPeriod{}, fmt.Errorf("unknown tariff level %q", level)
+	}
+	period := TariffPeriod{Level: level}
+	for _, bound := range []struct {
+		value string
+		into  *time.Duration
+	}{{start, &period.Start}, {end, &period.End}} {
+		t, err := time.Parse("15:04", bound.value)
+		if err != nil {
+			return TariffPeriod{}, fmt.Errorf("invalid time of day %q: %w", bound.value, err)
+		}
+		*bound.into = time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	}
+	return period, nil
+}
+
+func (p TariffPeriod) contains(t time.Time) bool {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	offset := t.Sub(midnight)
+	if p.Start <= p.End {
+		return p.Start <= offset && offset < p.End
+	}
+	return offset >= p.Start || offset < p.End
+}
+
+// Tariff pre-conditions rooms while electricity is cheap and lets them drift while it is expensive.
+type Tariff struct {
+	Periods []TariffPeriod
+	// How far (in °C) the comfort range is shifted.
+	Band   float64
+	feed   string
+	feedAt time.Time
+}
+
+// Level is the current tariff level, from the price feed if it's fresh and the configured periods otherwise.
+func (t *Tariff) Level() string {
+	if t.feed != "" && time.Since(t.feedAt) < tariffFeedMaxAge {
+		return t.feed
+	}
+	now := time.Now()
+	for _, period := range t.Periods {
+		if period.contains(now) {
+			return period.Level
+		}
+	}
+	return TariffNormal
+}
+
+func (t *Tariff) Adjust(hvac *Hvac, min float64, max float64) (float64, float64) {
+	switch t.Level() {
+	case TariffCheap:
+		// Store comfort while it's cheap, without crossing the middle of the range.
+		middle := (min + max) / 2
+		return math.Min(min+t.Band, middle), math.Max(max-t.Band, middle)
+	case TariffPeak:
+		return min - t.Band, max + t.Band
+	}
+	return min, max
+}
+
+// NewTariff configures the tariff periods. If feedTopic isn't empty, the current level ("cheap", "normal" or
+// "peak") is also read from it and takes precedence over the periods.
+func NewTariff(mqttClient paho.Client, feedTopic string, band float64, periods ...TariffPeriod) *Tariff {
+	t := Tariff{Periods: periods, Band: band}
+	if feedTopic != "" {
+		mqttClient.Subscribe(feedTopic, 1, func(c paho.Client, m paho.Message) {
+			L.Debug("Received", "topic", m.Topic(), "payload", m.Payload())
+			switch level := string(m.Payload()); level {
+			case TariffCheap, TariffNormal, TariffPeak:
+				at := time.Now()
+				utils.MainLoop.Do(func() {
+					t.feed = level
+					t.feedAt = at
+				})
+			default:
+				L.Error("Unknown tariff level", "topic", m.Topic(), "payload", m.Payload())
+			}
+		})
+	}
+	return &t
+}
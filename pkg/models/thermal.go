@@ -0,0 +1,126 @@
+package models
+
+import (
+	"encoding/json"
+	"math"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+
+	"github.com/nanassito/air/pkg/discovery"
+	"github.com/nanassito/air/pkg/utils"
+)
+
+// ThermalModel is what we learned of how fast the room temperature changes, in °C/hour.
+type ThermalModel struct {
+	// While the unit is heating or cooling, whatever the fan speed.
+	WarmUp   float64 `json:"warm_up"`
+	CoolDown float64 `json:"cool_down"`
+	// Same thing, per fan speed.
+	Heating map[string]float64 `json:"heating"`
+	Cooling map[string]float64 `json:"cooling"`
+	// How fast the room drifts towards the outdoor temperature while the unit is off, per °C of difference.
+	Leak float64 `json:"leak"`
+	// When the model last learned from the sensor history.
+	Measured time.Time `json:"measured"`
+}
+
+func newThermalModel() ThermalModel {
+	return ThermalModel{WarmUp: 1, CoolDown: 1, Heating: map[string]float64{}, Cooling: map[string]float64{}}
+}
+
+func (m *ThermalModel) HeatRate(fan string) float64 {
+	if rate, ok := m.Heating[fan]; ok {
+		return rate
+	}
+	return m.WarmUp
+}
+
+func (m *ThermalModel) CoolRate(fan string) float64 {
+	if rate, ok := m.Cooling[fan]; ok {
+		return rate
+	}
+	return m.CoolDown
+}
+
+// Drift is how fast the room temperature changes on its own, in °C/hour, using the outdoor temperature when we have
+// it and the recent trend otherwise.
+func (hvac *Hvac) Drift() float64 {
+	current, err := hvac.AutoPilot.Sensors.Air.Get()
+	if err != nil {
+		return 0
+	}
+	if outdoor := hvac.AutoPilot.Sensors.Outdoor; outdoor != nil && hvac.Thermal.Leak > 0 {
+		if outside, err := outdoor.Get(); err == nil {
+			return -hvac.Thermal.Leak * (current - outside)
+		}
+	}
+	return hvac.AutoPilot.Sensors.Air.GetRate()
+}
+
+// Forecast is the room temperature expected after d if the unit stays off.
+func (hvac *Hvac) Forecast(d time.Duration) float64 {
+	current, _ := hvac.AutoPilot.Sensors.Air.Get()
+	return current + hvac.Drift()*d.Hours()
+}
+
+// StartLead is how long before the room leaves the comfort range the unit needs to start at this fan speed, for
+// it to have time to gain a degree.
+func (hvac *Hvac) StartLead(mode string, fan string) time.Duration {
+	rate := hvac.Thermal.HeatRate(fan)
+	if mode == "COOL" {
+		rate = hvac.Thermal.CoolRate(fan)
+	}
+	hours := 3.0
+	if rate > 0 {
+		hours = math.Min(hours, 1/rate)
+	}
+	return time.Duration(hours * float64(time.Hour))
+}
+
+func (hvac *Hvac) thermalTopic() string {
+	return "air3/" + hvac.Name + "/thermal_model"
+}
+
+// PublishThermalModel shares the model with Home Assistant, retained so it survives a restart.
+func (hvac *Hvac) PublishThermalModel() {
+	payload, err := json.Marshal(hvac.Thermal)
+	if err != nil {
+		L.Error("Failed to serialize the thermal model", "err", err, "hvac", hvac.Name)
+		return
+	}
+	hvac.mqtt.Publish(hvac.thermalTopic(), 0, true, payload)
+}
+
+func (hvac *Hvac) restoreThermalModel() {
+	hvac.mqtt.Subscribe(hvac.thermalTopic(), 0, func(c paho.Client, m paho.Message) {
+		if !m.Retained() {
+			return // Our own updates.
+		}
+		L.Debug("Restoring", "topic", m.Topic(), "payload", m.Payload())
+		model := newThermalModel()
+		if err := json.Unmarshal(m.Payload(), &model); err != nil {
+			L.Error("Failed to parse mqtt message", "err", err, "topic", m.Topic(), "payload", m.Payload())
+			return
+		}
+		utils.MainLoop.Do(func() { hvac.Thermal = model })
+	})
+}
+
+func (hvac *Hvac) publishThermalDiscovery() {
+	for _, sensor := range []struct{ name, id, icon, key, unit string }{
+		{"Warm up rate", "warm_up_rate", "mdi:thermometer-chevron-up", "warm_up", "°C/h"},
+		{"Cool down rate", "cool_down_rate", "mdi:thermometer-chevron-down", "cool_down", "°C/h"},
+		{"Heat leak", "heat_leak", "mdi:home-thermometer-outline", "leak", "1/h"},
+	} {
+		entity := hvac.DiscoveryEntity(sensor.name, sensor.id, sensor.icon)
+		entity.EntityCategory = "diagnostic"
+		discovery.Publish(hvac.mqtt, entity.UniqueID, discovery.Sensor{
+			Entity:            entity,
+			StateTopic:        hvac.thermalTopic(),
+			ValueTemplate:     "{{ value_json." + sensor.key + " | round(3) }}",
+			UnitOfMeasurement: sensor.unit,
+			StateClass:        "measurement",
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package models
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+
+	"github.com/nanassito/air/pkg/discovery"
+	"github.com/nanassito/air/pkg/mqtt"
+)
+
+// Parameter is a runtime tunable of the autopilot, exposed as a number in Home Assistant.
+type Parameter struct {
+	*mqtt.ControlledValue[float64]
+	ID   string
+	Name string
+	Unit string
+	Min  float64
+	Max  float64
+	Step float64
+}
+
+// Minutes reads the parameter as a number of minutes.
+func (p *Parameter) Minutes() time.Duration {
+	return time.Duration(p.Get() * float64(time.Minute))
+}
+
+type tuning struct {
+	AntiFlap       *Parameter // Minutes before a unit can change mode again.
+	ShutdownBand   *Parameter // How far past the desired temperature we shut the unit down.
+	HeatScoreLimit *Parameter // Decision score needed to change the target temperature while heating.
+	ColdScoreLimit *Parameter // Decision score needed to change the target temperature while cooling.
+	HeatFloor      *Parameter // Lowest target temperature while heating.
+	BoostDuration  *Parameter // Minutes a boost lasts.
+	VacantAfter    *Parameter // Minutes without presence before the room is considered vacant.
+	VacantRelax    *Parameter // How much the setpoints are relaxed while the room is vacant.
+}
+
+func (t *tuning) all() []*Parameter {
+	return []*Parameter{t.AntiFlap, t.ShutdownBand, t.HeatScoreLimit, t.ColdScoreLimit, t.HeatFloor, t.BoostDuration, t.VacantAfter, t.VacantRelax}
+}
+
+func newParameter(mqttClient paho.Client, hvacName string, p Parameter, defaultValue float64) *Parameter {
+	p.ControlledValue = mqtt.NewPersistedControlledValue(
+		mqttClient,
+		"air3/"+hvacName+"/tuning/"+p.ID+"/command",
+		"air3/"+hvacName+"/tuning/"+p.ID+"/state",
+		func(payload []byte) (float64, error) {
+			value, err := strconv.ParseFloat(string(payload), 64)
+			if err == nil && (value < p.Min || value > p.Max) {
+				return 0, fmt.Errorf("%s out of range: %v", p.ID, value)
+			}
+			return value, err
+		},
+		func(value float64) string {
+			return strconv.FormatFloat(value, 'f', -1, 64)
+		},
+		defaultValue,
+	)
+	return &p
+}
+
+func newTuning(mqttClient paho.Client, hvacName string) *tuning {
+	return &tuning{
+		AntiFlap:       newParameter(mqttClient, hvacName, Parameter{ID: "anti_flap", Name: "Anti-flap delay", Unit: "min", Min: 0, Max: 240, Step: 5}, 30),
+		ShutdownBand:   newParameter(mqttClient, hvacName, Parameter{ID: "shutdown_band", Name: "Shutdown band", Unit: "°C", Min: 0.5, Max: 10, Step: 0.5}, 3),
+		HeatScoreLimit: newParameter(mqttClient, hvacName, Parameter{ID: "heat_score_limit", Name: "Heat decision score limit", Min: 1, Max: 1000, Step: 1}, 100),
+		ColdScoreLimit: newParameter(mqttClient, hvacName, Parameter{ID: "cold_score_limit", Name: "Cold decision score limit", Min: 1, Max: 1000, Step: 1}, 60),
+		HeatFloor:      newParameter(mqttClient, hvacName, Parameter{ID: "heat_floor", Name: "Heat floor", Unit: "°C", Min: 16, Max: 30, Step: 0.5}, 17),
+		BoostDuration:  newParameter(mqttClient, hvacName, Parameter{ID: "boost_duration", Name: "Boost duration", Unit: "min", Min: 5, Max: 120, Step: 5}, 20),
+		VacantAfter:    newParameter(mqttClient, hvacName, Parameter{ID: "vacant_after", Name: "Vacant after", Unit: "min", Min: 0, Max: 720, Step: 5}, 60),
+		VacantRelax:    newParameter(mqttClient, hvacName, Parameter{ID: "vacant_relax", Name: "Vacant setpoint relax", Unit: "°C", Min: 0, Max: 10, Step: 0.5}, 2),
+	}
+}
+
+func (hvac *Hvac) publishTuningDiscovery() {
+	for _, p := range hvac.AutoPilot.Tuning.all() {
+		entity := hvac.DiscoveryEntity(p.Name, p.ID, "mdi:tune")
+		entity.EntityCategory = "config"
+		discovery.Publish(hvac.mqtt, entity.UniqueID, discovery.Number{
+			Entity:            entity,
+			CommandTopic:      "air3/" + hvac.Name + "/tuning/" + p.ID + "/command",
+			StateTopic:        "air3/" + hvac.Name + "/tuning/" + p.ID + "/state",
+			Min:               p.Min,
+			Max:               p.Max,
+			Step:              p.Step,
+			UnitOfMeasurement: p.Unit,
+			Mode:              "box",
+		})
+	}
+}
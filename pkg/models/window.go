@@ -0,0 +1,46 @@
+package models
+
+import (
+	"time"
+
+	"github.com/nanassito/air/pkg/discovery"
+	"github.com/nanassito/air/pkg/mqtt"
+)
+
+// Window tracks whether a window seems to be open in the room, in which case the unit is paused.
+type Window struct {
+	Sensors []*mqtt.ContactSensor
+	Open    bool
+	Since   time.Time
+	Reason  string
+}
+
+// WithWindowSensors adds zigbee contact sensors reporting whether the windows of the room are open.
+func (hvac *Hvac) WithWindowSensors(topics ...string) *Hvac {
+	for _, topic := range topics {
+		hvac.Window.Sensors = append(hvac.Window.Sensors, mqtt.NewJsonContactSensor(hvac.mqtt, topic))
+	}
+	return hvac
+}
+
+func (hvac *Hvac) windowTopic() string {
+	return "air3/" + hvac.Name + "/window"
+}
+
+func (hvac *Hvac) PublishWindow() {
+	payload := "OFF"
+	if hvac.Window.Open {
+		payload = "ON"
+	}
+	hvac.mqtt.Publish(hvac.windowTopic(), 0, true, payload)
+}
+
+func (hvac *Hvac) publishWindowDiscovery() {
+	discovery.Publish(hvac.mqtt, hvac.Name+"_window", discovery.BinarySensor{
+		Entity:      hvac.DiscoveryEntity("Window open", "window", "mdi:window-open-variant"),
+		StateTopic:  hvac.windowTopic(),
+		PayloadOn:   "ON",
+		PayloadOff:  "OFF",
+		DeviceClass: "window",
+	})
+}
@@ -3,13 +3,16 @@ package mqtt
 import (
 	"fmt"
 	"os"
+	"time"
 
 	paho "github.com/eclipse/paho.mqtt.golang"
 
 	"github.com/nanassito/air/pkg/utils"
 )
 
-var L = utils.Logger
+var L = utils.NewLogger("mqtt")
+
+const AvailabilityTopic = "air3/status"
 
 func MustNewMqttClient(server string) paho.Client {
 	hostname, err := os.Hostname()
@@ -20,6 +23,12 @@ func MustNewMqttClient(server string) paho.Client {
 	opts := paho.NewClientOptions()
 	opts.SetClientID(fmt.Sprintf("air3-%s", hostname))
 	opts.AddBroker(server)
+	// Let Home Assistant know when we go away without saying goodbye.
+	opts.SetWill(AvailabilityTopic, "offline", 1, true)
+	opts.SetOnConnectHandler(func(client paho.Client) {
+		L.Info("Announcing availability.", "topic", AvailabilityTopic)
+		client.Publish(AvailabilityTopic, 1, true, "online")
+	})
 	opts.OnConnectionLost = func(client paho.Client, err error) {
 		L.Error("Lost mqtt connection", "err", err)
 		panic(err)
@@ -31,3 +40,33 @@ func MustNewMqttClient(server string) paho.Client {
 	}
 	return client
 }
+
+const LogLevelsTopic = "air3/log_levels"
+
+// ListenForLogLevels lets the log levels be changed at runtime by publishing e.g. "info,mqtt=debug" on
+// <LogLevelsTopic>/command, or "mqtt=default" to drop an override. The resulting levels are published on
+// <LogLevelsTopic>/state.
+func ListenForLogLevels(client paho.Client) {
+	publish := func() {
+		client.Publish(LogLevelsTopic+"/state", 0, true, utils.Levels())
+	}
+	client.Subscribe(LogLevelsTopic+"/command", 0, func(c paho.Client, m paho.Message) {
+		levels := string(m.Payload())
+		if err := utils.SetLevels(levels); err != nil {
+			L.Error("Invalid log levels", "levels", levels, "err", err)
+		} else {
+			L.Info("Changed log levels", "levels", utils.Levels())
+		}
+		publish()
+	}).Wait()
+	publish()
+}
+
+// Disconnect announces that air3 is going offline, then closes the connection once in-flight messages are sent.
+func Disconnect(client paho.Client) {
+	L.Info("Disconnecting from the Mqtt broker.")
+	if token := client.Publish(AvailabilityTopic, 1, true, "offline"); !token.WaitTimeout(time.Second) || token.Error() != nil {
+		L.Error("Failed to publish the offline availability", "err", token.Error())
+	}
+	client.Disconnect(250)
+}
@@ -1,12 +1,18 @@
 package mqtt
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"sort"
 	"strconv"
 	"time"
 
 	paho "github.com/eclipse/paho.mqtt.golang"
+
+	"github.com/nanassito/air/pkg/tsdb"
+	"github.com/nanassito/air/pkg/utils"
 )
 
 var (
@@ -14,21 +20,139 @@ var (
 	ErrNotInitializedYet = errors.New("not initialized yet")
 )
 
+// History, when set, persists every value so the histories survive a restart and can be queried over any
+// window. It must be set before any value is created.
+var History *tsdb.Store
+
 type valueWithHistory[T comparable] struct {
 	MaxAge   time.Duration
 	timeData map[time.Time]T
 	latest   time.Time
+	// When the value was last seen changing from one value to another, zero if we never saw it change.
+	changedAt time.Time
+	series    string
+	store     *tsdb.Store
+	observers []func(T)
+}
+
+// Sample is a value and when it was received.
+type Sample[T any] struct {
+	Time  time.Time `json:"time"`
+	Value T         `json:"value"`
+}
+
+func formatHistory(value any) string {
+	switch v := value.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func parseHistory[T comparable](payload string) (T, error) {
+	var value any
+	var err error
+	switch any(*new(T)).(type) {
+	case float64:
+		value, err = strconv.ParseFloat(payload, 64)
+	case bool:
+		value, err = strconv.ParseBool(payload)
+	case string:
+		value = payload
+	default:
+		err = fmt.Errorf("unsupported history type %T", *new(T))
+	}
+	if err != nil {
+		return *new(T), err
+	}
+	return value.(T), nil
+}
+
+// Record persists a value that isn't received over mqtt, such as an internal state, in the history.
+func Record(series string, value any) {
+	if History == nil {
+		return
+	}
+	if err := History.Append(series, time.Now(), formatHistory(value)); err != nil {
+		L.Error("Failed to persist the history", "err", err, "series", series)
+	}
+}
+
+func newValueWithHistory[T comparable](series string) *valueWithHistory[T] {
+	s := valueWithHistory[T]{
+		MaxAge:   1 * time.Hour,
+		timeData: map[time.Time]T{},
+		series:   series,
+		store:    History,
+	}
+	if s.store != nil {
+		// The latest value may be older than MaxAge, a day back is good enough to find it.
+		samples, err := s.Query(time.Now().Add(-24*time.Hour), time.Now())
+		if err != nil {
+			L.Error("Failed to restore the history", "err", err, "series", series)
+		}
+		for i, sample := range samples {
+			s.timeData[sample.Time] = sample.Value
+			s.latest = sample.Time
+			// The first sample may only be when we first heard of the value, e.g. after a restart.
+			if i > 0 && sample.Value != samples[i-1].Value {
+				s.changedAt = sample.Time
+			}
+		}
+		s.timeData = s.GetAllValues()
+	}
+	return &s
 }
 
 func (s *valueWithHistory[T]) Insert(newValue T) {
-	if value, ok := s.timeData[s.latest]; ok && value == newValue {
+	value, ok := s.timeData[s.latest]
+	if ok && value == newValue {
 		return // Value is unchanged
 	}
 	timeData := s.GetAllValues()
 	now := time.Now()
+	if ok {
+		s.changedAt = now
+	}
 	timeData[now] = newValue
 	s.latest = now
 	s.timeData = timeData
+	if s.store != nil {
+		if err := s.store.Append(s.series, now, formatHistory(newValue)); err != nil {
+			L.Error("Failed to persist the history", "err", err, "series", s.series)
+		}
+	}
+	for _, observer := range s.observers {
+		observer(newValue)
+	}
+}
+
+// Query returns the values received within [from, to], oldest first. Without a store, only the last MaxAge is known.
+func (s *valueWithHistory[T]) Query(from time.Time, to time.Time) ([]Sample[T], error) {
+	samples := make([]Sample[T], 0)
+	if s.store == nil {
+		for when, value := range s.GetAllValues() {
+			if !when.Before(from) && !when.After(to) {
+				samples = append(samples, Sample[T]{when, value})
+			}
+		}
+		sort.Slice(samples, func(i, j int) bool { return samples[i].Time.Before(samples[j].Time) })
+		return samples, nil
+	}
+	points, err := s.store.Query(s.series, from, to)
+	if err != nil {
+		return nil, err
+	}
+	for _, point := range points {
+		value, err := parseHistory[T](point.Value)
+		if err != nil {
+			L.Warn("Skipping an invalid history point", "err", err, "series", s.series, "value", point.Value)
+			continue
+		}
+		samples = append(samples, Sample[T]{point.Time, value})
+	}
+	return samples, nil
 }
 
 func (s *valueWithHistory[T]) GetAllValues() map[time.Time]T {
@@ -41,6 +165,16 @@ func (s *valueWithHistory[T]) GetAllValues() map[time.Time]T {
 	return result
 }
 
+// Observation is something that happened to a ThirdPartyValue.
+type Observation string
+
+const (
+	ObservedState   Observation = "state"
+	ObservedCommand Observation = "command"
+	ObservedAck     Observation = "ack"
+	ObservedNack    Observation = "nack"
+)
+
 type ThirdPartyValue[T bool | string | float64] struct {
 	mqtt         paho.Client
 	values       *valueWithHistory[T]
@@ -48,6 +182,20 @@ type ThirdPartyValue[T bool | string | float64] struct {
 	statusTopic  string
 	parser       func([]byte) (T, error)
 	formatter    func(T) string
+	acknowledged bool
+	observers    []func(Observation, T)
+}
+
+// Observe calls f whenever the device reports a new value, a command is sent, or a command is (not) acknowledged.
+func (s *ThirdPartyValue[T]) Observe(f func(Observation, T)) {
+	s.observers = append(s.observers, f)
+	s.values.observers = append(s.values.observers, func(value T) { f(ObservedState, value) })
+}
+
+func (s *ThirdPartyValue[T]) notify(observation Observation, value T) {
+	for _, observer := range s.observers {
+		observer(observation, value)
+	}
 }
 
 func (s *ThirdPartyValue[T]) IsReady() bool {
@@ -58,46 +206,74 @@ func (s *ThirdPartyValue[T]) Get() T {
 	return s.values.timeData[s.values.latest]
 }
 
+// LastChange is when the value last changed, if we know it.
+func (s *ThirdPartyValue[T]) LastChange() (time.Time, bool) {
+	if changedAt := s.values.changedAt; !changedAt.IsZero() {
+		return changedAt, true
+	}
+	return time.Time{}, false
+}
+
 func (s *ThirdPartyValue[T]) UnchangedFor() time.Duration {
-	if len(s.values.timeData) > 1 {
-		return time.Since(s.values.latest)
-	} else {
-		return 24 * time.Hour // Just something large enough since we don't really know
+	if changed, ok := s.LastChange(); ok {
+		return time.Since(changed)
 	}
+	return 24 * time.Hour // Just something large enough since we don't really know
+}
+
+func (s *ThirdPartyValue[T]) History(from time.Time, to time.Time) ([]Sample[T], error) {
+	return s.values.Query(from, to)
+}
+
+// IsAcknowledged reports whether the last command was confirmed by the device.
+func (s *ThirdPartyValue[T]) IsAcknowledged() bool {
+	return s.acknowledged
 }
 
-func (s *ThirdPartyValue[T]) Set(t T) {
+func (s *ThirdPartyValue[T]) Set(ctx context.Context, t T) {
+	s.acknowledged = false
 	rs := s.mqtt.Publish(s.commandTopic, qos, false, s.formatter(t))
 	rs.Wait()
 	if err := rs.Error(); err != nil {
 		L.Error("mqtt error", "err", err, "commandTopic", s.commandTopic)
 		return
 	}
+	s.notify(ObservedCommand, t)
 
 	// Check that the new value is acknowledged and retry every 100ms for up to 1s if it isn't
 	ticker := time.NewTicker(300 * time.Millisecond)
+	defer ticker.Stop()
 	for i := 0; i < 10; i++ {
-		<-ticker.C
+		select {
+		case <-ctx.Done():
+			L.Warn("Stopped waiting for the ThirdPartyValue acknowledgement", "desired", t, "acknowledged", s.Get(), "statusTopic", s.statusTopic, "err", ctx.Err())
+			return
+		case <-ticker.C:
+		}
 		if s.IsReady() && s.Get() == t {
+			s.acknowledged = true
+			s.notify(ObservedAck, t)
 			return
 		} else {
 			L.Warn("ThirdPartyValue was not acknowledged", "desired", t, "acknowledged", s.Get(), "statusTopic", s.statusTopic)
 		}
 	}
 	L.Error("Failed to set a ThirdPartyValue", "desired", t, "acknowledged", s.Get(), "statusTopic", s.statusTopic)
+	s.notify(ObservedNack, t)
 }
 
 func NewThirdPartyValue[T bool | string | float64](mqtt paho.Client, commandTopic string, statusTopic string, parser func([]byte) (T, error), formatter func(T) string) *ThirdPartyValue[T] {
 	s := ThirdPartyValue[T]{
 		mqtt:         mqtt,
-		values:       &valueWithHistory[T]{MaxAge: 1 * time.Hour},
+		values:       newValueWithHistory[T](statusTopic),
 		commandTopic: commandTopic,
 		statusTopic:  statusTopic,
 		parser:       parser,
 		formatter:    formatter,
+		acknowledged: true,
 	}
 	s.mqtt.Subscribe(s.statusTopic, qos, func(c paho.Client, m paho.Message) {
-		L.Info("Received", "topic", m.Topic(), "payload", m.Payload())
+		L.Debug("Received", "topic", m.Topic(), "payload", m.Payload())
 		value, err := s.parser(m.Payload())
 		if err != nil {
 			L.Error("Failed to parse mqtt message", "err", err, "topic", m.Topic(), "payload", m.Payload())
@@ -116,6 +292,7 @@ type ControlledValue[T bool | string | float64] struct {
 	parser       func([]byte) (T, error)
 	formatter    func(T) string
 	initialized  bool
+	retained     bool
 }
 
 func (s *ControlledValue[T]) IsReady() bool {
@@ -127,9 +304,12 @@ func (s *ControlledValue[T]) Get() T {
 }
 
 func (s *ControlledValue[T]) Set(t T) {
+	if !s.initialized || s.value != t {
+		Record(s.statusTopic, t)
+	}
 	s.value = t
 	s.initialized = true
-	s.mqtt.Publish(s.statusTopic, qos, false, s.formatter(t))
+	s.mqtt.Publish(s.statusTopic, qos, s.retained, s.formatter(t))
 }
 
 func NewControlledValue[T bool | string | float64](mqtt paho.Client, commandTopic string, statusTopic string, parser func([]byte) (T, error), formatter func(T) string) *ControlledValue[T] {
@@ -143,15 +323,52 @@ func NewControlledValue[T bool | string | float64](mqtt paho.Client, commandTopi
 		initialized:  false,
 	}
 	s.mqtt.Subscribe(s.commandTopic, qos, func(c paho.Client, m paho.Message) {
-		L.Info("Received", "topic", m.Topic(), "payload", m.Payload())
+		L.Debug("Received", "topic", m.Topic(), "payload", m.Payload())
+		topic, payload := m.Topic(), m.Payload()
+		utils.MainLoop.Do(func() {
+			if err := s.Command(payload); err != nil {
+				L.Error("Failed to parse mqtt message", "err", err, "topic", topic, "payload", payload)
+			}
+		})
+	})
+	return &s
+}
+
+// Parse validates a command payload the same way as the ones received over mqtt, without applying it.
+func (s *ControlledValue[T]) Parse(payload []byte) (T, error) {
+	return s.parser(payload)
+}
+
+// Command applies a command payload, validated the same way as the ones received over mqtt.
+func (s *ControlledValue[T]) Command(payload []byte) error {
+	value, err := s.Parse(payload)
+	if err != nil {
+		return err
+	}
+	s.Set(value)
+	return nil
+}
+
+// NewPersistedControlledValue is a ControlledValue whose state is retained by the broker so it survives a restart.
+// It starts with the default value until the retained state, if any, is received.
+func NewPersistedControlledValue[T bool | string | float64](mqtt paho.Client, commandTopic string, statusTopic string, parser func([]byte) (T, error), formatter func(T) string, defaultValue T) *ControlledValue[T] {
+	s := NewControlledValue(mqtt, commandTopic, statusTopic, parser, formatter)
+	s.retained = true
+	s.value = defaultValue
+	s.initialized = true
+	s.mqtt.Subscribe(s.statusTopic, qos, func(c paho.Client, m paho.Message) {
+		if !m.Retained() {
+			return // Our own updates.
+		}
+		L.Debug("Restoring", "topic", m.Topic(), "payload", m.Payload())
 		value, err := s.parser(m.Payload())
 		if err != nil {
 			L.Error("Failed to parse mqtt message", "err", err, "topic", m.Topic(), "payload", m.Payload())
 			return
 		}
-		s.Set(value)
+		utils.MainLoop.Do(func() { s.value = value })
 	})
-	return &s
+	return s
 }
 
 type TemperatureSensor struct {
@@ -177,6 +394,19 @@ const (
 	TrendCoolingDown
 )
 
+func (t Trend) String() string {
+	switch t {
+	case TrendWarmingUp:
+		return "warming_up"
+	case TrendStable:
+		return "stable"
+	case TrendCoolingDown:
+		return "cooling_down"
+	default:
+		return "unknown"
+	}
+}
+
 func (t *TemperatureSensor) GetTrend() Trend {
 	current, err := t.Get()
 	if err != nil {
@@ -235,12 +465,181 @@ func (t *TemperatureSensor) GetRange() float64 {
 	return max - min
 }
 
+// Observe calls f whenever the measured temperature changes.
+func (t *TemperatureSensor) Observe(f func(float64)) {
+	t.values.observers = append(t.values.observers, f)
+}
+
+func (t *TemperatureSensor) History(from time.Time, to time.Time) ([]Sample[float64], error) {
+	return t.values.Query(from, to)
+}
+
+// MaxSince is the highest temperature measured over the given duration, including the current one.
+func (t *TemperatureSensor) MaxSince(d time.Duration) float64 {
+	max, err := t.Get()
+	if err != nil {
+		return 0
+	}
+	for ts, measurement := range t.values.GetAllValues() {
+		if time.Since(ts) <= d && measurement > max {
+			max = measurement
+		}
+	}
+	return max
+}
+
+// GetRate is how fast the temperature changed over the history, in °C/hour.
+func (t *TemperatureSensor) GetRate() float64 {
+	current, err := t.Get()
+	if err != nil {
+		return 0
+	}
+	oldest, oldestValue := t.values.latest, current
+	for ts, measurement := range t.values.GetAllValues() {
+		if ts.Before(oldest) {
+			oldest, oldestValue = ts, measurement
+		}
+	}
+	elapsed := time.Since(oldest).Hours()
+	if oldest == t.values.latest || elapsed == 0 {
+		return 0
+	}
+	return (current - oldestValue) / elapsed
+}
+
+type ContactSensor struct {
+	values *valueWithHistory[bool]
+}
+
+type ContactMqttPayload struct {
+	Contact bool `json:"contact"`
+}
+
+// IsOpen reports whether the door or window is open, closed if we haven't heard from the sensor yet.
+func (c *ContactSensor) IsOpen() bool {
+	contact, ok := c.values.timeData[c.values.latest]
+	return ok && !contact
+}
+
+func NewJsonContactSensor(mqtt paho.Client, topic string) *ContactSensor {
+	c := ContactSensor{
+		values: newValueWithHistory[bool](topic),
+	}
+	mqtt.Subscribe(topic, qos, func(cl paho.Client, m paho.Message) {
+		L.Debug("Received", "topic", m.Topic(), "payload", m.Payload())
+		parsed := ContactMqttPayload{}
+		err := json.Unmarshal(m.Payload(), &parsed)
+		if err != nil {
+			L.Error("Failed to parse mqtt message", "err", err, "topic", m.Topic(), "payload", m.Payload())
+			return
+		}
+		c.values.Insert(parsed.Contact)
+	})
+	return &c
+}
+
+// OccupancySensor remembers when someone was last seen in the room.
+type OccupancySensor struct {
+	occupied bool
+	lastSeen time.Time
+}
+
+type OccupancyMqttPayload struct {
+	Occupancy *bool `json:"occupancy"`
+	Presence  *bool `json:"presence"`
+}
+
+func (o *OccupancySensor) set(occupied bool) {
+	if occupied || o.occupied || o.lastSeen.IsZero() {
+		o.lastSeen = time.Now()
+	}
+	o.occupied = occupied
+}
+
+func (o *OccupancySensor) IsOccupied() bool {
+	return o.occupied
+}
+
+// LastSeen is when someone was last detected, zero if we haven't heard from the sensor yet.
+func (o *OccupancySensor) LastSeen() time.Time {
+	return o.lastSeen
+}
+
+// NewJsonOccupancySensor reads zigbee motion or presence sensors.
+func NewJsonOccupancySensor(mqtt paho.Client, topic string) *OccupancySensor {
+	o := OccupancySensor{}
+	mqtt.Subscribe(topic, qos, func(cl paho.Client, m paho.Message) {
+		L.Debug("Received", "topic", m.Topic(), "payload", m.Payload())
+		parsed := OccupancyMqttPayload{}
+		err := json.Unmarshal(m.Payload(), &parsed)
+		if err != nil {
+			L.Error("Failed to parse mqtt message", "err", err, "topic", m.Topic(), "payload", m.Payload())
+			return
+		}
+		switch {
+		case parsed.Occupancy != nil:
+			o.set(*parsed.Occupancy)
+		case parsed.Presence != nil:
+			o.set(*parsed.Presence)
+		}
+	})
+	return &o
+}
+
+// NewPresenceSensor reads a plain state such as a Home Assistant person ("home", "not_home") forwarded over mqtt.
+func NewPresenceSensor(mqtt paho.Client, topic string) *OccupancySensor {
+	o := OccupancySensor{}
+	mqtt.Subscribe(topic, qos, func(cl paho.Client, m paho.Message) {
+		L.Debug("Received", "topic", m.Topic(), "payload", m.Payload())
+		switch string(m.Payload()) {
+		case "home", "on", "ON", "true":
+			o.set(true)
+		default:
+			o.set(false)
+		}
+	})
+	return &o
+}
+
+// PowerMeter reads the instant power draw, in W.
+type PowerMeter struct {
+	values *valueWithHistory[float64]
+}
+
+type PowerMqttPayload struct {
+	Power float64 `json:"power"`
+}
+
+func (p *PowerMeter) Get() (float64, error) {
+	if len(p.values.timeData) == 0 {
+		return 0, ErrNotInitializedYet
+	}
+	return p.values.timeData[p.values.latest], nil
+}
+
+func NewJsonPowerMeter(mqtt paho.Client, topic string) *PowerMeter {
+	p := PowerMeter{
+		values: newValueWithHistory[float64](topic),
+	}
+	mqtt.Subscribe(topic, qos, func(c paho.Client, m paho.Message) {
+		L.Debug("Received", "topic", m.Topic(), "payload", m.Payload())
+		parsed := PowerMqttPayload{}
+		err := json.Unmarshal(m.Payload(), &parsed)
+		if err != nil {
+			L.Error("Failed to parse mqtt message", "err", err, "topic", m.Topic(), "payload", m.Payload())
+			return
+		}
+		p.values.Insert(parsed.Power)
+	})
+	return &p
+}
+
 func NewJsonTemperatureSensor(mqtt paho.Client, topic string) *TemperatureSensor {
 	t := TemperatureSensor{
-		values: &valueWithHistory[float64]{MaxAge: 1 * time.Hour},
+		values: newValueWithHistory[float64](topic),
 	}
 	mqtt.Subscribe(topic, qos, func(c paho.Client, m paho.Message) {
-		L.Info("Received", "topic", m.Topic(), "payload", m.Payload())
+		L.Debug("Received", "topic", m.Topic(), "payload", m.Payload())
 		parsed := SensorMqttPayload{}
 		err := json.Unmarshal(m.Payload(), &parsed)
 		if err != nil {
@@ -254,10 +653,10 @@ func NewJsonTemperatureSensor(mqtt paho.Client, topic string) *TemperatureSensor
 
 func NewRawTemperatureSensor(mqtt paho.Client, topic string) *TemperatureSensor {
 	t := TemperatureSensor{
-		values: &valueWithHistory[float64]{MaxAge: 1 * time.Hour},
+		values: newValueWithHistory[float64](topic),
 	}
 	mqtt.Subscribe(topic, qos, func(c paho.Client, m paho.Message) {
-		L.Info("Received", "topic", m.Topic(), "payload", m.Payload())
+		L.Debug("Received", "topic", m.Topic(), "payload", m.Payload())
 		value, err := strconv.ParseFloat(string(m.Payload()), 64)
 		if err != nil {
 			L.Error("Failed to parse mqtt message", "err", err, "topic", m.Topic(), "payload", m.Payload())
@@ -1,6 +1,7 @@
 package mqtt_test
 
 import (
+	"context"
 	"strconv"
 	"testing"
 	"time"
@@ -9,6 +10,8 @@ import (
 	"github.com/matryer/is"
 	"github.com/nanassito/air/pkg/mocks"
 	"github.com/nanassito/air/pkg/mqtt"
+	"github.com/nanassito/air/pkg/tsdb"
+	"github.com/nanassito/air/pkg/utils"
 )
 
 func Test3rdPartyValue(t *testing.T) {
@@ -27,17 +30,73 @@ func Test3rdPartyValue(t *testing.T) {
 		func(value bool) string { return strconv.FormatBool(value) },
 	)
 
-	v.Set(true)
+	v.Set(context.Background(), true)
 	is.True(v.IsReady())
+	is.True(v.IsAcknowledged())
 	is.True(v.Get())
 
-	v.Set(false)
-	v.Set(false)
+	v.Set(context.Background(), false)
+	v.Set(context.Background(), false)
 	is.Equal(false, v.Get())
 
 	is.True(v.UnchangedFor() < 1*time.Second)
 }
 
+func TestSetDuringShutdown(t *testing.T) {
+	is := is.New(t)
+	mockMqtt := mocks.NewMockMqtt()
+	mockMqtt.Subscribe("command", 0, func(c paho.Client, m paho.Message) {
+		mockMqtt.Publish("status", 0, false, m.Payload())
+	})
+	v := mqtt.NewThirdPartyValue(
+		mockMqtt,
+		"command",
+		"status",
+		func(payload []byte) (bool, error) { return strconv.ParseBool(string(payload)) },
+		func(value bool) string { return strconv.FormatBool(value) },
+	)
+	shutdown, stop := context.WithCancel(context.Background())
+	stop()
+
+	// Interrupted right away by the shutdown itself.
+	v.Set(shutdown, true)
+	is.True(!v.IsAcknowledged())
+
+	// Completed when given the grace period.
+	ctx, cancel := utils.WithGracePeriod(shutdown, 5*time.Second)
+	defer cancel()
+	v.Set(ctx, false)
+	is.True(v.IsAcknowledged())
+}
+
+func TestPersistedControlledValue(t *testing.T) {
+	is := is.New(t)
+	mockMqtt := mocks.NewMockMqtt()
+	newValue := func() *mqtt.ControlledValue[float64] {
+		return mqtt.NewPersistedControlledValue(
+			mockMqtt,
+			"command",
+			"state",
+			func(payload []byte) (float64, error) { return strconv.ParseFloat(string(payload), 64) },
+			func(value float64) string { return strconv.FormatFloat(value, 'f', -1, 64) },
+			10,
+		)
+	}
+
+	v := newValue()
+	is.Equal(10.0, v.Get()) // Nothing to restore yet.
+	mockMqtt.Publish("command", 0, false, "12")
+	utils.MainLoop.RunPending()
+	is.Equal(12.0, v.Get())
+	mockMqtt.Publish("state", 0, false, "99") // Not retained, ignored.
+	is.Equal(12.0, v.Get())
+
+	// A restart gets the retained state back.
+	restored := newValue()
+	utils.MainLoop.RunPending()
+	is.Equal(12.0, restored.Get())
+}
+
 func TestGetRange(t *testing.T) {
 	is := is.New(t)
 	mockMqtt := mocks.NewMockMqtt()
@@ -55,3 +114,82 @@ func TestGetRange(t *testing.T) {
 	mockMqtt.Publish("topic", 0, false, "24.5")
 	is.Equal(0.5, s.GetRange())
 }
+
+func TestHistory(t *testing.T) {
+	is := is.New(t)
+	store, err := tsdb.Open(t.TempDir(), 24*time.Hour)
+	is.NoErr(err)
+	mqtt.History = store
+	defer func() { mqtt.History = nil }()
+
+	mockMqtt := mocks.NewMockMqtt()
+	mqtt.NewRawTemperatureSensor(mockMqtt, "sensor")
+	mockMqtt.Publish("sensor", 0, false, "20")
+	mockMqtt.Publish("sensor", 0, false, "21")
+
+	// A restart gets the history back.
+	restored := mqtt.NewRawTemperatureSensor(mocks.NewMockMqtt(), "sensor")
+	current, err := restored.Get()
+	is.NoErr(err)
+	is.Equal(21.0, current)
+	samples, err := restored.History(time.Now().Add(-time.Hour), time.Now())
+	is.NoErr(err)
+	is.Equal(2, len(samples))
+	is.Equal(20.0, samples[0].Value)
+}
+
+func TestUnchangedForAfterRestart(t *testing.T) {
+	is := is.New(t)
+	store, err := tsdb.Open(t.TempDir(), 24*time.Hour)
+	is.NoErr(err)
+	mqtt.History = store
+	defer func() { mqtt.History = nil }()
+	is.NoErr(store.Append("status", time.Now().Add(-3*time.Hour), "OFF"))
+	is.NoErr(store.Append("status", time.Now().Add(-90*time.Minute), "COOL"))
+
+	// Restarting 90 minutes after the mode changed.
+	v := mqtt.NewThirdPartyValue(
+		mocks.NewMockMqtt(),
+		"command",
+		"status",
+		func(payload []byte) (string, error) { return string(payload), nil },
+		func(value string) string { return value },
+	)
+	is.Equal("COOL", v.Get())
+	unchanged := v.UnchangedFor()
+	is.True(unchanged > 89*time.Minute && unchanged < 91*time.Minute)
+	changed, ok := v.LastChange()
+	is.True(ok)
+	is.True(time.Since(changed) < 91*time.Minute)
+
+	t.Run("unknown from a single sample", func(t *testing.T) {
+		// All we know is when it was first heard of, not when it changed.
+		is.NoErr(store.Append("other_status", time.Now().Add(-10*time.Minute), "OFF"))
+		v := mqtt.NewThirdPartyValue(
+			mocks.NewMockMqtt(),
+			"other_command",
+			"other_status",
+			func(payload []byte) (string, error) { return string(payload), nil },
+			func(value string) string { return value },
+		)
+		is.Equal("OFF", v.Get())
+		_, ok := v.LastChange()
+		is.True(!ok)
+	})
+
+	t.Run("repeated samples aren't a change", func(t *testing.T) {
+		is.NoErr(store.Append("repeated_status", time.Now().Add(-3*time.Hour), "OFF"))
+		is.NoErr(store.Append("repeated_status", time.Now().Add(-2*time.Hour), "COOL"))
+		is.NoErr(store.Append("repeated_status", time.Now().Add(-time.Hour), "COOL"))
+		v := mqtt.NewThirdPartyValue(
+			mocks.NewMockMqtt(),
+			"repeated_command",
+			"repeated_status",
+			func(payload []byte) (string, error) { return string(payload), nil },
+			func(value string) string { return value },
+		)
+		changed, ok := v.LastChange()
+		is.True(ok)
+		is.True(time.Since(changed) > 119*time.Minute)
+	})
+}
@@ -0,0 +1,116 @@
+package scheduler
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nanassito/air/pkg/utils"
+)
+
+var L = utils.NewLogger("scheduler")
+
+type Timer interface {
+	Stop() bool
+}
+
+// Clock abstracts time so scheduled actions can be tested without waiting.
+type Clock interface {
+	Now() time.Time
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                            { return time.Now() }
+func (realClock) AfterFunc(d time.Duration, f func()) Timer { return time.AfterFunc(d, f) }
+
+var RealClock Clock = realClock{}
+
+type Pending struct {
+	Name string    `json:"name"`
+	At   time.Time `json:"at"`
+}
+
+type action struct {
+	Pending
+	timer Timer
+}
+
+// Scheduler runs named follow-up actions after a delay, unless they get cancelled first.
+type Scheduler struct {
+	clock Clock
+	// Where the due actions run, e.g. queued on the main loop, rather than on the timer goroutine.
+	run     func(func())
+	lock    sync.Mutex
+	actions map[string]*action
+}
+
+// Schedule runs f after the delay, replacing any pending action with the same name.
+// The action is dropped if the context is done by then.
+func (s *Scheduler) Schedule(ctx context.Context, name string, delay time.Duration, f func(context.Context)) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if previous, ok := s.actions[name]; ok {
+		previous.timer.Stop()
+	}
+	a := &action{Pending: Pending{Name: name, At: s.clock.Now().Add(delay)}}
+	a.timer = s.clock.AfterFunc(delay, func() {
+		s.run(func() {
+			s.lock.Lock()
+			if s.actions[name] != a {
+				s.lock.Unlock()
+				return // Cancelled or replaced in the meantime.
+			}
+			delete(s.actions, name)
+			s.lock.Unlock()
+			if err := ctx.Err(); err != nil {
+				L.Info("Dropping scheduled action", "action", name, "err", err)
+				return
+			}
+			f(ctx)
+		})
+	})
+	s.actions[name] = a
+}
+
+func (s *Scheduler) Cancel(name string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if a, ok := s.actions[name]; ok {
+		L.Info("Cancelling scheduled action", "action", name)
+		a.timer.Stop()
+		delete(s.actions, name)
+	}
+}
+
+func (s *Scheduler) CancelAll() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for name, a := range s.actions {
+		L.Info("Cancelling scheduled action", "action", name)
+		a.timer.Stop()
+		delete(s.actions, name)
+	}
+}
+
+func (s *Scheduler) Pending() []Pending {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	pending := make([]Pending, 0, len(s.actions))
+	for _, a := range s.actions {
+		pending = append(pending, a.Pending)
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].At.Before(pending[j].At) })
+	return pending
+}
+
+// New returns a scheduler whose due actions are handed over to run.
+func New(clock Clock, run func(func())) *Scheduler {
+	return &Scheduler{
+		clock:   clock,
+		run:     run,
+		actions: make(map[string]*action),
+	}
+}
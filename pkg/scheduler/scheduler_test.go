@@ -0,0 +1,58 @@
+package scheduler_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+
+	"github.com/nanassito/air/pkg/mocks"
+	"github.com/nanassito/air/pkg/scheduler"
+)
+
+func TestScheduler(t *testing.T) {
+	is := is.New(t)
+	clock := mocks.NewFakeClock()
+	queued := []func(){}
+	s := scheduler.New(clock, func(f func()) { queued = append(queued, f) })
+	runQueued := func() {
+		for _, f := range queued {
+			f()
+		}
+		queued = nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ran := map[string]int{}
+	s.Schedule(ctx, "first", time.Minute, func(context.Context) { ran["first"]++ })
+	s.Schedule(ctx, "second", 2*time.Minute, func(context.Context) { ran["second"]++ })
+	s.Schedule(ctx, "second", 3*time.Minute, func(context.Context) { ran["replaced"]++ })
+	is.Equal(2, len(s.Pending()))
+	is.Equal("first", s.Pending()[0].Name)
+
+	clock.Advance(2 * time.Minute)
+	is.Equal(0, ran["first"]) // Handed over, not run on the timer.
+	runQueued()
+	is.Equal(1, ran["first"])
+	is.Equal(0, ran["second"])
+	is.Equal(1, len(s.Pending()))
+
+	s.Cancel("second")
+	clock.Advance(time.Hour)
+	runQueued()
+	is.Equal(0, ran["replaced"])
+	is.Equal(0, len(s.Pending()))
+
+	s.Schedule(ctx, "shutdown", time.Minute, func(context.Context) { ran["shutdown"]++ })
+	cancel()
+	clock.Advance(time.Hour)
+	runQueued()
+	is.Equal(0, ran["shutdown"])
+
+	s.Schedule(ctx, "cancelled once due", time.Minute, func(context.Context) { ran["cancelled once due"]++ })
+	clock.Advance(time.Minute)
+	s.CancelAll()
+	runQueued()
+	is.Equal(0, ran["cancelled once due"])
+}
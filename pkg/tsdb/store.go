@@ -0,0 +1,284 @@
+// Package tsdb is a small embedded time-series store: one append-only segment file per day, older segments
+// downsampled and expired ones deleted whenever a new segment is started.
+package tsdb
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nanassito/air/pkg/utils"
+)
+
+var L = utils.NewLogger("tsdb")
+
+const (
+	dayLayout         = "2006-01-02"
+	rawSuffix         = ".tsv"
+	downsampledSuffix = ".ds.tsv"
+)
+
+type Point struct {
+	Time  time.Time `json:"time"`
+	Value string    `json:"value"`
+}
+
+type Store struct {
+	dir string
+	// Segments older than that are deleted.
+	Retention time.Duration
+	// Segments older than that only keep the last value of each series per DownsampleStep.
+	DownsampleAfter time.Duration
+	DownsampleStep  time.Duration
+
+	// Only queried, never written to nor compacted.
+	readOnly bool
+
+	mu      sync.Mutex
+	segment *os.File
+	day     string
+}
+
+func Open(dir string, retention time.Duration) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &Store{
+		dir:             dir,
+		Retention:       retention,
+		DownsampleAfter: 7 * 24 * time.Hour,
+		DownsampleStep:  5 * time.Minute,
+	}, nil
+}
+
+// ErrReadOnly is returned when writing to a store opened with OpenReadOnly.
+var ErrReadOnly = errors.New("the history is read-only")
+
+// OpenReadOnly opens an existing store to query it, leaving its retention to whoever writes to it.
+func OpenReadOnly(dir string) (*Store, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+	return &Store{dir: dir, readOnly: true}, nil
+}
+
+func dayOf(t time.Time) string {
+	return t.UTC().Format(dayLayout)
+}
+
+// Append records the value of a series at the given time.
+func (s *Store) Append(series string, at time.Time, value string) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if day := dayOf(at); s.segment == nil || day != s.day {
+		if err := s.roll(day); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(s.segment, "%s\t%s\t%s\n", at.UTC().Format(time.RFC3339Nano), series, strconv.Quote(value))
+	return err
+}
+
+func (s *Store) roll(day string) error {
+	if s.segment != nil {
+		if err := s.segment.Close(); err != nil {
+			L.Error("Failed to close the history segment", "err", err, "day", s.day)
+		}
+	}
+	segment, err := os.OpenFile(filepath.Join(s.dir, day+rawSuffix), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		s.segment = nil
+		return err
+	}
+	s.segment, s.day = segment, day
+	if err := s.compact(time.Now()); err != nil {
+		L.Error("Failed to compact the history", "err", err)
+	}
+	return nil
+}
+
+type segment struct {
+	day  string
+	path string
+}
+
+func (s segment) isDownsampled() bool {
+	return strings.HasSuffix(s.path, downsampledSuffix)
+}
+
+func (s *Store) segments() ([]segment, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	segments := make([]segment, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		for _, suffix := range []string{downsampledSuffix, rawSuffix} {
+			if day, ok := strings.CutSuffix(name, suffix); ok {
+				if _, err := time.Parse(dayLayout, day); err == nil {
+					segments = append(segments, segment{day, filepath.Join(s.dir, name)})
+				}
+				break
+			}
+		}
+	}
+	return segments, nil
+}
+
+func readSegment(path string, f func(series string, p Point)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), "\t", 3)
+		if len(fields) != 3 {
+			continue // Torn write.
+		}
+		at, err := time.Parse(time.RFC3339Nano, fields[0])
+		if err != nil {
+			continue
+		}
+		value, err := strconv.Unquote(fields[2])
+		if err != nil {
+			continue
+		}
+		f(fields[1], Point{Time: at, Value: value})
+	}
+	return scanner.Err()
+}
+
+// Query returns the points of a series within [from, to], oldest first.
+func (s *Store) Query(series string, from time.Time, to time.Time) ([]Point, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	segments, err := s.segments()
+	if err != nil {
+		return nil, err
+	}
+	points := make([]Point, 0)
+	for _, segment := range segments {
+		if segment.day < dayOf(from) || segment.day > dayOf(to) {
+			continue
+		}
+		err := readSegment(segment.path, func(name string, p Point) {
+			if name == series && !p.Time.Before(from) && !p.Time.After(to) {
+				points = append(points, p)
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.SliceStable(points, func(i, j int) bool { return points[i].Time.Before(points[j].Time) })
+	return points, nil
+}
+
+// Compact deletes expired segments and downsamples old ones.
+func (s *Store) Compact() error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.compact(time.Now())
+}
+
+func (s *Store) compact(now time.Time) error {
+	segments, err := s.segments()
+	if err != nil {
+		return err
+	}
+	for _, segment := range segments {
+		switch {
+		case segment.day == s.day:
+			continue
+		case segment.day < dayOf(now.Add(-s.Retention)):
+			L.Info("Deleting expired history", "day", segment.day)
+			if err := os.Remove(segment.path); err != nil {
+				return err
+			}
+		case segment.day < dayOf(now.Add(-s.DownsampleAfter)) && !segment.isDownsampled():
+			L.Info("Downsampling history", "day", segment.day)
+			if err := s.downsample(segment); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Store) downsample(raw segment) error {
+	type bucket struct {
+		series string
+		start  time.Time
+	}
+	last := map[bucket]Point{}
+	keep := func(series string, p Point) {
+		key := bucket{series, p.Time.Truncate(s.DownsampleStep)}
+		if kept, ok := last[key]; !ok || !p.Time.Before(kept.Time) {
+			last[key] = p
+		}
+	}
+	target := filepath.Join(s.dir, raw.day+downsampledSuffix)
+	if _, err := os.Stat(target); err == nil {
+		if err := readSegment(target, keep); err != nil {
+			return err
+		}
+	}
+	if err := readSegment(raw.path, keep); err != nil {
+		return err
+	}
+	keys := make([]bucket, 0, len(last))
+	for key := range last {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return last[keys[i]].Time.Before(last[keys[j]].Time) })
+
+	tmp, err := os.CreateTemp(s.dir, raw.day+".*.tmp")
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(tmp)
+	for _, key := range keys {
+		p := last[key]
+		fmt.Fprintf(writer, "%s\t%s\t%s\n", p.Time.UTC().Format(time.RFC3339Nano), key.series, strconv.Quote(p.Value))
+	}
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), target); err != nil {
+		return err
+	}
+	return os.Remove(raw.path)
+}
+
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.segment == nil {
+		return nil
+	}
+	err := s.segment.Close()
+	s.segment = nil
+	return err
+}
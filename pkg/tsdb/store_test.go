@@ -0,0 +1,87 @@
+package tsdb_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+
+	"github.com/nanassito/air/pkg/tsdb"
+)
+
+func TestStore(t *testing.T) {
+	is := is.New(t)
+	dir := t.TempDir()
+	store, err := tsdb.Open(dir, 30*24*time.Hour)
+	is.NoErr(err)
+	defer store.Close()
+
+	now := time.Now()
+	is.NoErr(store.Append("temp", now.Add(-2*time.Minute), "20.5"))
+	is.NoErr(store.Append("other", now.Add(-time.Minute), "tab\tand\nnewline"))
+	is.NoErr(store.Append("temp", now, "21"))
+
+	points, err := store.Query("temp", now.Add(-time.Hour), now)
+	is.NoErr(err)
+	is.Equal(2, len(points))
+	is.Equal("20.5", points[0].Value)
+	is.Equal("21", points[1].Value)
+
+	points, err = store.Query("other", now.Add(-time.Hour), now)
+	is.NoErr(err)
+	is.Equal("tab\tand\nnewline", points[0].Value)
+
+	points, err = store.Query("temp", now.Add(-time.Hour), now.Add(-time.Minute))
+	is.NoErr(err)
+	is.Equal(1, len(points))
+}
+
+func TestCompaction(t *testing.T) {
+	is := is.New(t)
+	dir := t.TempDir()
+	store, err := tsdb.Open(dir, 30*24*time.Hour)
+	is.NoErr(err)
+	defer store.Close()
+
+	expired := time.Now().Add(-40 * 24 * time.Hour)
+	old := time.Now().Add(-10 * 24 * time.Hour).Truncate(time.Hour)
+	is.NoErr(store.Append("temp", expired, "10"))
+	for i := 0; i < 10; i++ {
+		is.NoErr(store.Append("temp", old.Add(time.Duration(i)*time.Minute), "20"))
+	}
+	// Starting a new segment compacts the previous ones.
+	is.NoErr(store.Append("temp", time.Now(), "21"))
+
+	points, err := store.Query("temp", expired.Add(-time.Hour), old.Add(time.Hour))
+	is.NoErr(err)
+	is.Equal(2, len(points)) // One per 5 minutes.
+	is.Equal(old.Add(4*time.Minute), points[0].Time.Local())
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.tsv"))
+	is.NoErr(err)
+	is.Equal(2, len(files))
+	_, err = os.Stat(filepath.Join(dir, old.UTC().Format("2006-01-02")+".ds.tsv"))
+	is.NoErr(err)
+}
+
+func TestReadOnly(t *testing.T) {
+	is := is.New(t)
+	dir := t.TempDir()
+	store, err := tsdb.Open(dir, 30*24*time.Hour)
+	is.NoErr(err)
+	is.NoErr(store.Append("temp", time.Now(), "21"))
+	is.NoErr(store.Close())
+
+	readOnly, err := tsdb.OpenReadOnly(dir)
+	is.NoErr(err)
+	points, err := readOnly.Query("temp", time.Now().Add(-time.Hour), time.Now())
+	is.NoErr(err)
+	is.Equal(1, len(points))
+	is.Equal(tsdb.ErrReadOnly, readOnly.Append("temp", time.Now(), "22"))
+	is.Equal(tsdb.ErrReadOnly, readOnly.Compact())
+
+	_, err = tsdb.OpenReadOnly(filepath.Join(dir, "missing"))
+	is.True(err != nil)
+}
@@ -0,0 +1,27 @@
+package utils
+
+import (
+	"context"
+	"time"
+)
+
+// WithGracePeriod returns a context that outlives shutdown by the grace period, so work already in flight when
+// shutdown is done gets a bounded amount of time to complete instead of being interrupted.
+func WithGracePeriod(shutdown context.Context, grace time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-ctx.Done():
+			return
+		case <-shutdown.Done():
+		}
+		timer := time.NewTimer(grace)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+		case <-timer.C:
+			cancel()
+		}
+	}()
+	return ctx, cancel
+}
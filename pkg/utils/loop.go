@@ -0,0 +1,55 @@
+package utils
+
+import "context"
+
+// Loop hands work over to the main loop, which owns the settings and the state of the models: the commands, presets
+// and restored states received over mqtt, the http requests and the scheduled actions all go through it instead of
+// racing with the autopilot. Device and sensor readings are still recorded as they arrive.
+type Loop struct {
+	jobs chan func()
+}
+
+// MainLoop is drained by the autopilot loop in main, and before every autopilot run.
+var MainLoop = NewLoop(1024)
+
+func NewLoop(size int) *Loop {
+	return &Loop{jobs: make(chan func(), size)}
+}
+
+// Do queues f to run on the main loop.
+func (l *Loop) Do(f func()) {
+	l.jobs <- f
+}
+
+// Jobs is what the main loop receives the queued work from.
+func (l *Loop) Jobs() <-chan func() {
+	return l.jobs
+}
+
+// Wait queues f and waits for the main loop to have run it, unless ctx is done first.
+func (l *Loop) Wait(ctx context.Context, f func()) error {
+	done := make(chan struct{})
+	select {
+	case l.jobs <- func() { f(); close(done) }:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// RunPending runs everything queued so far. It must only be called from the main loop.
+func (l *Loop) RunPending() {
+	for {
+		select {
+		case f := <-l.jobs:
+			f()
+		default:
+			return
+		}
+	}
+}
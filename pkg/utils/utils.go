@@ -1,18 +1,170 @@
 package utils
 
 import (
+	"context"
+	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 
 	"golang.org/x/exp/slog"
 )
 
-var Logger = slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+var handlerOptions = &slog.HandlerOptions{
 	AddSource: true,
+	// Levels are enforced per subsystem before reaching the handlers.
+	Level: slog.LevelDebug,
 	ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
-		if a.Key == slog.SourceKey {
-			a.Value = slog.StringValue(filepath.Base(a.Value.String()))
+		if src, ok := a.Value.Any().(*slog.Source); ok && a.Key == slog.SourceKey {
+			a.Value = slog.AnyValue(&slog.Source{Function: src.Function, File: filepath.Base(src.File), Line: src.Line})
 		}
 		return a
 	},
-}))
+}
+
+var (
+	textHandler = slog.NewTextHandler(os.Stdout, handlerOptions)
+	jsonHandler = slog.NewJSONHandler(os.Stdout, handlerOptions)
+	jsonOutput  atomic.Bool
+
+	defaultLevel = new(slog.LevelVar)
+	levelsMu     sync.Mutex
+	// Subsystems whose level differs from the default one.
+	levels = map[string]*slog.LevelVar{}
+	// Subsystems that have a logger, the only ones a level can be set for.
+	subsystems = map[string]bool{}
+)
+
+// DefaultLevel removes the level of a subsystem in SetLevels, e.g. "mqtt=default", so it follows the default again.
+const DefaultLevel = "default"
+
+// SetFormat switches every logger to "text" or "json" output.
+func SetFormat(format string) error {
+	switch format {
+	case "text":
+		jsonOutput.Store(false)
+	case "json":
+		jsonOutput.Store(true)
+	default:
+		return fmt.Errorf("unknown log format: %q", format)
+	}
+	return nil
+}
+
+func levelOf(subsystem string) slog.Level {
+	levelsMu.Lock()
+	defer levelsMu.Unlock()
+	if level, ok := levels[subsystem]; ok {
+		return level.Level()
+	}
+	return defaultLevel.Level()
+}
+
+// SetLevels parses levels such as "info,mqtt=warn,logic=debug", where the entry without a subsystem is the default.
+// The whole spec is validated before any level changes.
+func SetLevels(spec string) error {
+	type change struct {
+		subsystem string
+		level     slog.Level
+		reset     bool
+	}
+	changes := make([]change, 0)
+	levelsMu.Lock()
+	defer levelsMu.Unlock()
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		subsystem, level, found := strings.Cut(entry, "=")
+		if !found {
+			subsystem, level = "", entry
+		}
+		c := change{subsystem: strings.TrimSpace(subsystem)}
+		if c.subsystem != "" && !subsystems[c.subsystem] {
+			return fmt.Errorf("unknown subsystem: %q", c.subsystem)
+		}
+		if level = strings.TrimSpace(level); level == DefaultLevel && c.subsystem != "" {
+			c.reset = true
+		} else if err := c.level.UnmarshalText([]byte(level)); err != nil {
+			return err
+		}
+		changes = append(changes, c)
+	}
+	for _, c := range changes {
+		switch {
+		case c.subsystem == "":
+			defaultLevel.Set(c.level)
+		case c.reset:
+			delete(levels, c.subsystem)
+		default:
+			if _, ok := levels[c.subsystem]; !ok {
+				levels[c.subsystem] = new(slog.LevelVar)
+			}
+			levels[c.subsystem].Set(c.level)
+		}
+	}
+	return nil
+}
+
+// Levels describes the current levels, in the format SetLevels accepts.
+func Levels() string {
+	levelsMu.Lock()
+	defer levelsMu.Unlock()
+	entries := []string{defaultLevel.Level().String()}
+	for subsystem, level := range levels {
+		entries = append(entries, subsystem+"="+level.Level().String())
+	}
+	sort.Strings(entries[1:])
+	return strings.Join(entries, ",")
+}
+
+// handler sends records to the text or json handler, whichever is selected at the time, if the level of its
+// subsystem allows it.
+type handler struct {
+	subsystem string
+	// WithAttrs and WithGroup calls, replayed on the selected handler.
+	with []func(slog.Handler) slog.Handler
+}
+
+func (h *handler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= levelOf(h.subsystem)
+}
+
+func (h *handler) Handle(ctx context.Context, record slog.Record) error {
+	var output slog.Handler = textHandler
+	if jsonOutput.Load() {
+		output = jsonHandler
+	}
+	for _, with := range h.with {
+		output = with(output)
+	}
+	return output.Handle(ctx, record)
+}
+
+func (h *handler) withFunc(f func(slog.Handler) slog.Handler) *handler {
+	with := make([]func(slog.Handler) slog.Handler, len(h.with), len(h.with)+1)
+	copy(with, h.with)
+	return &handler{subsystem: h.subsystem, with: append(with, f)}
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.withFunc(func(output slog.Handler) slog.Handler { return output.WithAttrs(attrs) })
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	return h.withFunc(func(output slog.Handler) slog.Handler { return output.WithGroup(name) })
+}
+
+// NewLogger is the logger of a subsystem, whose level can be changed independently at runtime.
+func NewLogger(subsystem string) *slog.Logger {
+	levelsMu.Lock()
+	subsystems[subsystem] = true
+	levelsMu.Unlock()
+	return slog.New(&handler{subsystem: subsystem}).With("subsystem", subsystem)
+}
+
+var Logger = NewLogger("main")
@@ -0,0 +1,55 @@
+package utils_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+	"golang.org/x/exp/slog"
+
+	"github.com/nanassito/air/pkg/utils"
+)
+
+func TestLevels(t *testing.T) {
+	is := is.New(t)
+	defer utils.SetLevels("info,mqtt=default,logic=default")
+	mqttLogger, logicLogger, modelsLogger := utils.NewLogger("mqtt"), utils.NewLogger("logic"), utils.NewLogger("models")
+
+	is.NoErr(utils.SetLevels("warn, mqtt=error,logic=debug"))
+	is.Equal("WARN,logic=DEBUG,mqtt=ERROR", utils.Levels())
+	is.True(!mqttLogger.Enabled(context.Background(), slog.LevelWarn))
+	is.True(logicLogger.Enabled(context.Background(), slog.LevelDebug))
+	is.True(!modelsLogger.Enabled(context.Background(), slog.LevelInfo))
+	is.True(modelsLogger.Enabled(context.Background(), slog.LevelWarn))
+
+	is.True(utils.SetLevels("mqtt=loud") != nil)
+	// Nothing is applied unless the whole spec is valid.
+	is.True(utils.SetLevels("debug,mqtt=info,attic=debug") != nil)
+	is.True(utils.SetLevels("debug,mqtt=info,logic=loud") != nil)
+	is.Equal("WARN,logic=DEBUG,mqtt=ERROR", utils.Levels())
+
+	is.NoErr(utils.SetLevels("mqtt=default"))
+	is.Equal("WARN,logic=DEBUG", utils.Levels())
+	is.True(mqttLogger.Enabled(context.Background(), slog.LevelWarn))
+	is.True(utils.SetLevels("default") != nil) // The default level can't follow itself.
+	is.True(utils.SetFormat("xml") != nil)
+	is.NoErr(utils.SetFormat("json"))
+	is.NoErr(utils.SetFormat("text"))
+}
+
+func TestWithGracePeriod(t *testing.T) {
+	is := is.New(t)
+	shutdown, stop := context.WithCancel(context.Background())
+	ctx, cancel := utils.WithGracePeriod(shutdown, 50*time.Millisecond)
+	defer cancel()
+
+	stop()
+	time.Sleep(10 * time.Millisecond)
+	is.NoErr(ctx.Err()) // Still within the grace period.
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("the grace period never ended")
+	}
+}